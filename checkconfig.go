@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/papertrail/remote_syslog2/utils"
+)
+
+// A ConfigProblem is something wrong with a setting, found at Key, a YAML
+// key path like files[2].include_patterns. File is set when the problem is
+// in an included file. Warnings, like keys that aren't settings, don't
+// stop remote_syslog from starting.
+type ConfigProblem struct {
+	File    string
+	Key     string
+	Message string
+	Warning bool
+}
+
+func (p ConfigProblem) String() string {
+	s := p.Message
+	if p.Key != "" {
+		s = p.Key + ": " + s
+	}
+	if p.File != "" {
+		s = p.File + ": " + s
+	}
+	return s
+}
+
+// A ConfigError lists every problem found in a configuration
+type ConfigError []ConfigProblem
+
+func (e ConfigError) Error() string {
+	if len(e) == 1 {
+		return e[0].String()
+	}
+
+	problems := make([]string, len(e))
+	for i, p := range e {
+		problems[i] = "  " + p.String()
+	}
+	return fmt.Sprintf("%d problems in the configuration:\n%s", len(e), strings.Join(problems, "\n"))
+}
+
+// add records err as a problem at key. Errors that are already a list of
+// problems keep their own keys.
+func (e *ConfigError) add(key string, err error) {
+	switch err := err.(type) {
+	case nil:
+	case ConfigError:
+		*e = append(*e, err...)
+	default:
+		*e = append(*e, ConfigProblem{Key: key, Message: err.Error()})
+	}
+}
+
+// errors returns the problems that aren't warnings, or nil if there are
+// none
+func (e ConfigError) errors() error {
+	var errs ConfigError
+	for _, p := range e {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// mapstructure quotes the name of the field it failed to decode, relative
+// to what it was decoding
+var decodeField = regexp.MustCompile(`'([\w.\[\]]+)'`)
+
+// decodeProblems turns an error decoding key into problems. prefix is the
+// key path of what was being decoded.
+func decodeProblems(prefix, key string, err error) ConfigError {
+	errs, ok := err.(*mapstructure.Error)
+	if !ok {
+		return ConfigError{{Key: joinKey(prefix, key), Message: err.Error()}}
+	}
+
+	problems := make(ConfigError, len(errs.Errors))
+	for i, msg := range errs.Errors {
+		problems[i] = ConfigProblem{Key: joinKey(prefix, key), Message: msg}
+		if m := decodeField.FindStringSubmatch(msg); m != nil {
+			problems[i].Key = joinKey(prefix, strings.ToLower(m[1]))
+		}
+	}
+	return problems
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// decodeSettings decodes each of settings into result on its own, so a bad
+// value doesn't hide problems with the others. prefix is the key path of
+// settings, and keys that result has no field for are warned about.
+func decodeSettings(prefix string, settings map[string]interface{}, result interface{}) ConfigError {
+	var problems ConfigError
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var meta mapstructure.Metadata
+
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Result:           result,
+			WeaklyTypedInput: true,
+			DecodeHook:       decodeHook,
+			Metadata:         &meta,
+		})
+		if err == nil {
+			err = decoder.Decode(map[string]interface{}{key: settings[key]})
+		}
+		if err != nil {
+			problems = append(problems, decodeProblems(prefix, key, err)...)
+			continue
+		}
+
+		for _, unused := range meta.Unused {
+			problems = append(problems, ConfigProblem{
+				Key:     joinKey(prefix, strings.ToLower(unused)),
+				Message: "Unknown setting",
+				Warning: true,
+			})
+		}
+	}
+
+	return problems
+}
+
+// stringKeys returns a mapping from the config file with string keys
+func stringKeys(m interface{}) map[string]interface{} {
+	settings := make(map[string]interface{})
+
+	switch m := m.(type) {
+	case map[interface{}]interface{}:
+		for key, value := range m {
+			settings[fmt.Sprint(key)] = value
+		}
+	case map[string]interface{}:
+		for key, value := range m {
+			settings[key] = value
+		}
+	}
+
+	return settings
+}
+
+// decodeConfig decodes viper's settings into c, returning every problem
+// found
+func decodeConfig(settings map[string]interface{}, c *Config) ConfigError {
+	rest := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		// viper adds a flattened copy of nested settings bound to flags,
+		// like destination.host, and the config file flag itself
+		if strings.Contains(key, ".") || key == "config_file" {
+			continue
+		}
+		rest[key] = value
+	}
+
+	var problems ConfigError
+
+	// files are decoded apart so each entry's problems have its own key
+	if files, ok := rest["files"]; ok {
+		delete(rest, "files")
+
+		var err error
+		c.Files, err = decodeLogFiles(files)
+		problems.add("files", err)
+	}
+
+	return append(problems, decodeSettings("", rest, c)...)
+}
+
+// Check writes which files each glob currently matches, and which of
+// those would be skipped, followed by every problem with the
+// configuration. Unlike Validate, warnings are problems too.
+func (c *Config) Check(w io.Writer) error {
+	problems := append(ConfigError{}, c.problems...)
+
+	for i, glob := range c.Files {
+		fmt.Fprintf(w, "files[%d] %s\n", i, glob.Path)
+
+		files, err := filepath.Glob(utils.ResolvePath(glob.Path))
+		if err != nil {
+			problems = append(problems, ConfigProblem{
+				Key:     fmt.Sprintf("files[%d].path", i),
+				Message: err.Error(),
+			})
+			continue
+		}
+		if len(files) == 0 {
+			fmt.Fprintf(w, "  matches no files\n")
+		}
+
+		for _, file := range files {
+			switch {
+			case matchExps(file, c.ExcludeFiles):
+				fmt.Fprintf(w, "  %s (skipped: %s)\n", file, ruleString("exclude_files", firstMatch(file, c.ExcludeFiles)))
+			case len(c.IncludeFiles) > 0 && !matchExps(file, c.IncludeFiles):
+				fmt.Fprintf(w, "  %s (skipped: no include_files match)\n", file)
+			default:
+				fmt.Fprintf(w, "  %s\n", file)
+			}
+		}
+	}
+
+	problems.add("", c.Validate())
+
+	switch len(problems) {
+	case 0:
+		fmt.Fprintln(w, "No problems found")
+		return nil
+	case 1:
+		fmt.Fprintln(w, "1 problem found:")
+	default:
+		fmt.Fprintf(w, "%d problems found:\n", len(problems))
+	}
+	for _, p := range problems {
+		kind := "error"
+		if p.Warning {
+			kind = "warning"
+		}
+		fmt.Fprintf(w, "  %s: %s\n", kind, p)
+	}
+
+	return problems
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeConfigProblems(t *testing.T) {
+	assert := assert.New(t)
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(strings.NewReader(`
+files:
+  - /var/log/*.log
+  - path: /var/log/app.log
+    tagg: app
+    include_patterns:
+      - "[unclosed"
+    rate_limit:
+      lines_per_second: lots
+  - 42
+  - tag: nopath
+destination:
+  hosst: logs.example.com
+exclude_patterns:
+  - "(bad"
+severity: loud
+tcp_max_line_length: 1000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{}
+	problems := decodeConfig(v.AllSettings(), c)
+
+	var keys []string
+	for _, p := range problems {
+		keys = append(keys, p.Key)
+	}
+	assert.Equal([]string{
+		"files[1].include_patterns",
+		"files[1].rate_limit.lines_per_second",
+		"files[1].tagg",
+		"files[2]",
+		"files[3].path",
+		"destination.hosst",
+		"exclude_patterns",
+		"severity",
+	}, keys)
+
+	// unknown keys are only warnings
+	assert.True(problems[2].Warning)
+	assert.True(problems[5].Warning)
+	assert.False(problems[0].Warning)
+
+	// settings without problems are still decoded
+	assert.Equal(1000, c.TcpMaxLineLength)
+	assert.Equal([]LogFile{{Path: "/var/log/*.log"}}, c.Files)
+}
+
+func TestCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, name := range []string{"check-a.log", "check-excluded.log"} {
+		f := createFile(t, tmpdir+"/"+name)
+		f.Close()
+		defer os.Remove(f.Name())
+	}
+
+	c := &Config{
+		NewFileCheckInterval: time.Second,
+		ExcludeFiles:         []*regexp.Regexp{regexp.MustCompile("excluded")},
+		Files:                []LogFile{{Path: tmpdir + "/check-*.log"}, {Path: tmpdir + "/missing/*.log"}},
+		problems:             ConfigError{{Key: "files[0].tagg", Message: "Unknown setting", Warning: true}},
+	}
+
+	var out bytes.Buffer
+	err := c.Check(&out)
+
+	// the warning and the missing destination are both problems
+	if assert.IsType(ConfigError{}, err) {
+		assert.Len(err.(ConfigError), 2)
+	}
+
+	assert.Equal(`files[0] ./tmp/check-*.log
+  tmp/check-a.log
+  tmp/check-excluded.log (skipped: exclude_files "excluded")
+files[1] ./tmp/missing/*.log
+  matches no files
+2 problems found:
+  warning: files[0].tagg: Unknown setting
+  error: destination.host: No destination hostname specified
+`, out.String())
+
+	c.problems = nil
+	c.Destination.Host = "localhost"
+	assert.NoError(c.Check(&out))
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Config{OnTruncate: "ignore", DryRunFormat: "xml"}
+	c.Destination.Protocol = "smtp"
+	c.Files = []LogFile{{Path: "a.log", StartPosition: StartPosition{Mode: StartSaved}}}
+
+	err := c.Validate()
+	if assert.IsType(ConfigError{}, err) {
+		var keys []string
+		for _, p := range err.(ConfigError) {
+			keys = append(keys, p.Key)
+		}
+		assert.Equal([]string{
+			"destination.host",
+			"destination.protocol",
+			"new_file_check_interval",
+			"dry_run_format",
+			"on_truncate",
+			"files[0].start_position",
+		}, keys)
+	}
+}
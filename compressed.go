@@ -0,0 +1,82 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// isCompressed reports whether a file is compressed, going by its name
+func isCompressed(file string) bool {
+	switch filepath.Ext(file) {
+	case ".gz", ".zst":
+		return true
+	}
+	return false
+}
+
+// openLog opens a log file for reading, decompressing it if log rotation
+// compressed it with gzip or zstd
+func openLog(file string) (io.ReadCloser, error) {
+	switch filepath.Ext(file) {
+	case ".zst":
+		return openZstd(file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.Ext(file) != ".gz" {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &gzipFile{gz, f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+func openZstd(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// one goroutine is enough for a log file, and doesn't start more per
+	// file when many are read at once
+	zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &zstdFile{zr, f}, nil
+}
+
+type zstdFile struct {
+	*zstd.Decoder
+	file *os.File
+}
+
+func (z *zstdFile) Close() error {
+	z.Decoder.Close()
+	return z.file.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenLog(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "one\ntwo\n"
+
+	plain := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(plain, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gzipped := plain + ".1.gz"
+	f, err := os.Create(gzipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(content))
+	gz.Close()
+	f.Close()
+
+	zstded := plain + ".2.zst"
+	f, err = os.Create(zstded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write([]byte(content))
+	zw.Close()
+	f.Close()
+
+	files := []string{plain, gzipped, zstded}
+
+	for _, file := range files {
+		r, err := openLog(file)
+		if !assert.NoError(err, file) {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(r)
+		assert.NoError(err, file)
+		assert.Equal(content, string(data), file)
+		assert.NoError(r.Close(), file)
+	}
+
+	assert.False(isCompressed(plain))
+	assert.True(isCompressed(gzipped))
+	assert.True(isCompressed("app.log.3.zst"))
+
+	_, err = openLog(filepath.Join(dir, "missing.log.zst"))
+	assert.Error(err)
+}
@@ -12,7 +12,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/mitchellh/mapstructure"
 	"github.com/papertrail/remote_syslog2/syslog"
 	"github.com/papertrail/remote_syslog2/utils"
 	"github.com/spf13/pflag"
@@ -31,6 +30,7 @@ var (
 const (
 	envPrefix         = "remote_syslog"
 	defaultConfigFile = "/etc/log_files.yml"
+	defaultProtocol   = "udp"
 )
 
 // The global Config object for remote_syslog2 server. "mapstructure" tags
@@ -39,32 +39,88 @@ type Config struct {
 	ConnectTimeout       time.Duration    `mapstructure:"connect_timeout"`
 	WriteTimeout         time.Duration    `mapstructure:"write_timeout"`
 	NewFileCheckInterval time.Duration    `mapstructure:"new_file_check_interval"`
+	ShutdownTimeout      time.Duration    `mapstructure:"shutdown_timeout"`
+	RateLimitSummary     time.Duration    `mapstructure:"rate_limit_summary_interval"`
+	RateLimit            RateLimit        `mapstructure:"rate_limit"`
+	Health               Health           `mapstructure:"health"`
+	OwnLog               OwnLog           `mapstructure:"forward_own_log"`
 	ExcludeFiles         []*regexp.Regexp `mapstructure:"exclude_files"`
 	ExcludePatterns      []*regexp.Regexp `mapstructure:"exclude_patterns"`
+	IncludeFiles         []*regexp.Regexp `mapstructure:"include_files"`
+	IncludePatterns      []*regexp.Regexp `mapstructure:"include_patterns"`
 	LogLevels            string           `mapstructure:"log_levels"`
+	LogFormat            string           `mapstructure:"log_format"`
 	DebugLogFile         string           `mapstructure:"debug_log_file"`
+	DebugLogMaxSize      int64            `mapstructure:"debug_log_max_size"`
+	DebugLogKeep         int              `mapstructure:"debug_log_keep"`
 	PidFile              string           `mapstructure:"pid_file"`
+	User                 string           `mapstructure:"user"`
+	Group                string           `mapstructure:"group"`
+	KeepReadCapability   bool             `mapstructure:"keep_read_capability"`
+	StateFile            string           `mapstructure:"state_file"`
+	StartPosition        StartPosition    `mapstructure:"start_position"`
+	OnTruncate           string           `mapstructure:"on_truncate"`
+	Include              []string         `mapstructure:"include"`
 	TcpMaxLineLength     int              `mapstructure:"tcp_max_line_length"`
 	NoDetach             bool             `mapstructure:"no_detach"`
+	DryRun               bool             `mapstructure:"dry_run"`
+	DryRunFormat         string           `mapstructure:"dry_run_format"`
 	TCP                  bool             `mapstructure:"tcp"`
 	TLS                  bool             `mapstructure:"tls"`
 	Files                []LogFile
+	Redact               []*RedactRule
+	Fields               map[string]string
 	Hostname             string
 	Severity             syslog.Priority
 	Facility             syslog.Priority
 	Poll                 bool
 	Destination          struct {
-		Host     string
-		Port     int
-		Protocol string
-		Token    string
+		Host      string
+		Port      int
+		Protocol  string
+		Token     string
+		TokenFile string `mapstructure:"token_file"`
 	}
 	RootCAs *x509.CertPool
+
+	// Command is the subcommand named by the first argument, if any, and
+	// Args are the arguments that follow it
+	Command string
+	Args    []string
+
+	// Since and Until limit the lines send forwards by their timestamps
+	Since time.Time
+	Until time.Time
+
+	// problems found while reading the configuration, which check-config
+	// reports rather than stopping at
+	problems ConfigError
 }
 
 type LogFile struct {
-	Path string
-	Tag  string
+	Path      string
+	Tag       string
+	Hostname  string
+	RateLimit RateLimit `mapstructure:"rate_limit"`
+	Dedup     Dedup
+	Redact    []*RedactRule
+
+	// IncludePatterns replaces the global include_patterns for this file
+	IncludePatterns []*regexp.Regexp `mapstructure:"include_patterns"`
+
+	// PathPattern is matched against each file the glob finds; its
+	// captures can be referenced as %{name} or %{1} in Tag, Hostname and
+	// the values of Fields
+	PathPattern *regexp.Regexp `mapstructure:"path_pattern"`
+
+	// Fields are added to the global fields, replacing any with the same name
+	Fields map[string]string
+
+	// StartPosition replaces the global start_position for this file
+	StartPosition StartPosition `mapstructure:"start_position"`
+
+	// OnTruncate replaces the global on_truncate for this file
+	OnTruncate string `mapstructure:"on_truncate"`
 }
 
 func init() {
@@ -78,11 +134,14 @@ func initConfigAndFlags() {
 	config.SetEnvPrefix(envPrefix)
 
 	// set defaults for configuration values that aren't provided by flags here:
-	config.SetDefault("destination.protocol", "udp")
+	config.SetDefault("destination.protocol", defaultProtocol)
 	config.SetDefault("tcp_max_line_length", 99990)
 	config.SetDefault("debug_log_file", "/dev/null")
+	config.SetDefault("debug_log_keep", defaultDebugLogKeep)
 	config.SetDefault("connect_timeout", 30*time.Second)
 	config.SetDefault("write_timeout", 30*time.Second)
+	config.SetDefault("rate_limit_summary_interval", defaultRateLimitSummaryInterval)
+	config.SetDefault("on_truncate", TruncateRestart)
 
 	// flag-only "configuration" values (help and version)
 	flags.BoolP("help", "h", false, "Display this help message")
@@ -101,6 +160,24 @@ func initConfigAndFlags() {
 	flags.StringP("dest-token", "t", "", "Destination ingestion token")
 	config.BindPFlag("destination.token", flags.Lookup("dest-token"))
 
+	flags.String("dest-token-file", "", "File to read the destination ingestion token from")
+	config.BindPFlag("destination.token_file", flags.Lookup("dest-token-file"))
+
+	flags.String("health-listen", "", "Address to serve /healthz and /readyz on, like 127.0.0.1:8088")
+	config.BindPFlag("health.listen", flags.Lookup("health-listen"))
+
+	flags.String("forward-own-log", "", "Also send our own log records at or above this level, like WARNING, to the destination")
+	config.BindPFlag("forward_own_log.level", flags.Lookup("forward-own-log"))
+
+	flags.String("user", "", "User to switch to once the files found on startup are open")
+	config.BindPFlag("user", flags.Lookup("user"))
+
+	flags.String("group", "", "Group to switch to; defaults to the user's")
+	config.BindPFlag("group", flags.Lookup("group"))
+
+	flags.Bool("keep-read-capability", false, "Keep CAP_DAC_READ_SEARCH after switching user, to read any file")
+	config.BindPFlag("keep_read_capability", flags.Lookup("keep-read-capability"))
+
 	flags.StringP("facility", "f", "user", "Facility")
 	config.BindPFlag("facility", flags.Lookup("facility"))
 
@@ -126,18 +203,40 @@ func initConfigAndFlags() {
 	flags.Int("new-file-check-interval", 10, "How often to check for new files (seconds)")
 	config.BindPFlag("new_file_check_interval", flags.Lookup("new-file-check-interval"))
 
+	flags.String("start-position", "", "Where to start reading files: end, beginning, saved, \"last N lines\" or \"last N bytes\"")
+	config.BindPFlag("start_position", flags.Lookup("start-position"))
+
+	flags.String("state-file", "", "Where to save how far each file has been forwarded")
+	config.BindPFlag("state_file", flags.Lookup("state-file"))
+
+	flags.Bool("dry-run", false, "Print the packets that would be sent, and the lines that wouldn't, instead of sending them")
+	config.BindPFlag("dry_run", flags.Lookup("dry-run"))
+
+	flags.String("dry-run-format", DryRunRFC5424, "How --dry-run prints packets: rfc5424 or json")
+	config.BindPFlag("dry_run_format", flags.Lookup("dry-run-format"))
+
+	flags.Int("shutdown-timeout", 10, "How long to wait for queued messages to be sent on shutdown (seconds)")
+	config.BindPFlag("shutdown_timeout", flags.Lookup("shutdown-timeout"))
+
 	flags.String("debug-log-cfg", "", "The debug log file; overridden by -D/--no-detach")
 	config.BindPFlag("debug_log_file", flags.Lookup("debug-log-cfg"))
 
 	flags.String("log", "<root>=INFO", "Set loggo config, like: --log=\"<root>=DEBUG\"")
 	config.BindPFlag("log_levels", flags.Lookup("log"))
 
+	flags.String("log-format", LogFormatText, "Format of our own log: text or json")
+	config.BindPFlag("log_format", flags.Lookup("log-format"))
+
 	// only present this flag to systems that can daemonize
 	if utils.CanDaemonize {
 		flags.BoolP("no-detach", "D", false, "Don't daemonize and detach from the terminal; overrides --debug-log-cfg")
 		config.BindPFlag("no_detach", flags.Lookup("no-detach"))
 	}
 
+	// flags for the send subcommand, which aren't configuration
+	flags.String("since", "", "With send, only send lines logged at or after this time")
+	flags.String("until", "", "With send, only send lines logged before this time")
+
 	// deprecated flags
 	flags.Bool("no-eventmachine-tail", false, "No action, provided for backwards compatibility")
 	flags.Bool("eventmachine-tail", false, "No action, provided for backwards compatibility")
@@ -163,12 +262,26 @@ func NewConfigFromEnv() (*Config, error) {
 	}
 
 	c := &Config{}
+	args := flags.Args()
+
+	// the first argument may name a subcommand, which takes the rest
+	if len(args) > 0 && commands[args[0]] {
+		c.Command, args = args[0], args[1:]
+	}
+	checking := c.Command == "check-config"
+
+	// stopping only needs the pid file, so shouldn't fail on a
+	// configuration that has since been broken
+	stopping := c.Command == "stop"
 
 	// read in config file if it's there
 	configFile := config.GetString("config_file")
 	config.SetConfigFile(configFile)
-	if err := config.ReadInConfig(); err != nil && configFile != defaultConfigFile {
-		return nil, err
+	if err := config.ReadInConfig(); err != nil && (configFile != defaultConfigFile || checking) && !stopping {
+		if !checking {
+			return nil, err
+		}
+		c.problems = append(c.problems, ConfigProblem{Message: fmt.Sprintf("Failed to read %s: %s", configFile, err)})
 	}
 
 	// override daemonize setting for platforms that don't support it
@@ -177,24 +290,27 @@ func NewConfigFromEnv() (*Config, error) {
 	}
 
 	// unmarshal environment config into our Config object here
-	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		Result:           c,
-		WeaklyTypedInput: true,
-		DecodeHook:       decodeHook,
-	})
-	if err != nil {
-		return nil, err
-	}
+	c.problems = append(c.problems, decodeConfig(config.AllSettings(), c)...)
 
-	if err = decoder.Decode(config.AllSettings()); err != nil {
-		return nil, err
+	// merge in the included files, without an include setting those in
+	// the config file's .d directory
+	include := c.Include
+	if include == nil {
+		include = []string{defaultInclude(configFile)}
 	}
+	c.problems = append(c.problems, c.include(configFile, include)...)
 
 	// explicitly set destination fields since they are nested
 	c.Destination.Host = config.GetString("destination.host")
 	c.Destination.Port = config.GetInt("destination.port")
 	c.Destination.Protocol = config.GetString("destination.protocol")
 	c.Destination.Token = config.GetString("destination.token")
+	c.Destination.TokenFile = config.GetString("destination.token_file")
+	c.problems = append(c.problems, c.resolveToken()...)
+
+	// the flag is only seen through viper, as the setting is nested
+	c.Health.Listen = config.GetString("health.listen")
+	c.OwnLog.Level = config.GetString("forward_own_log.level")
 
 	// explicitly set destination protocol if we've asked for tcp or tls
 	if c.TLS {
@@ -209,28 +325,154 @@ func NewConfigFromEnv() (*Config, error) {
 		c.PidFile = getPidFile()
 	}
 
+	if c.Command == "send" {
+		c.Args, args = args, nil
+
+		now := time.Now()
+		for name, t := range map[string]*time.Time{"since": &c.Since, "until": &c.Until} {
+			if v, _ := flags.GetString(name); v != "" {
+				var err error
+				if *t, err = parseTimeArg(v, now); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
 	// collect any extra args passed on the command line and add them to our file list
-	for _, file := range flags.Args() {
+	for _, file := range args {
 		files, err := decodeLogFiles([]interface{}{file})
 		if err != nil {
-			return nil, err
+			c.problems.add("", fmt.Errorf("Invalid log file argument %s", file))
+			continue
 		}
 
 		c.Files = append(c.Files, files...)
 	}
 
+	if checking || stopping {
+		return c, nil
+	}
+
+	for _, p := range c.problems {
+		if p.Warning {
+			log.Warningf("Config: %s", p)
+		}
+	}
+
+	if err := c.problems.errors(); err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
 
+// resolveToken reads the token from token_file, or resolves the file: or
+// ${VAR} reference it holds, so it needn't be in the config file or on the
+// command line, where ps would show it
+func (c *Config) resolveToken() ConfigError {
+	var (
+		problems ConfigError
+		err      error
+		d        = &c.Destination
+	)
+
+	switch {
+	case d.TokenFile != "" && d.Token != "":
+		problems.add("destination.token_file", fmt.Errorf("Set either token or token_file, not both"))
+	case d.TokenFile != "":
+		d.Token, err = readSecretFile(d.TokenFile)
+		problems.add("destination.token_file", err)
+	default:
+		d.Token, err = resolveSecret(d.Token)
+		problems.add("destination.token", err)
+	}
+
+	return problems
+}
+
+// commands are the subcommands the first argument can name
+var commands = map[string]bool{
+	"send":         true,
+	"check-config": true,
+	"stop":         true,
+	"restart":      true,
+	"reload":       true,
+}
+
+// Validate returns a ConfigError listing every problem with the settings
+// that decoding them didn't catch
 func (c *Config) Validate() error {
-	if c.Destination.Host == "" {
-		return fmt.Errorf("No destination hostname specified")
+	var problems ConfigError
+
+	// a dry run doesn't send anything, so doesn't need a destination
+	if c.Destination.Host == "" && !c.DryRun {
+		problems.add("destination.host", fmt.Errorf("No destination hostname specified"))
+	}
+
+	if c.Destination.Port < 0 || c.Destination.Port > 65535 {
+		problems.add("destination.port", fmt.Errorf("Invalid port %d", c.Destination.Port))
+	}
+
+	// as when the setting is left out, since the logger can't dial without
+	// one
+	if c.Destination.Protocol == "" {
+		c.Destination.Protocol = defaultProtocol
+	}
+
+	switch c.Destination.Protocol {
+	case "udp", "tcp", "tls":
+	default:
+		problems.add("destination.protocol", fmt.Errorf("Invalid protocol %q, must be udp, tcp or tls", c.Destination.Protocol))
+	}
+
+	if c.Command == "send" && len(c.Args) == 0 {
+		problems.add("", fmt.Errorf("send needs at least one file"))
 	}
 
 	if c.NewFileCheckInterval < 1*time.Second {
-		return fmt.Errorf("new_file_check_interval is too small, try setting >= 1")
+		problems.add("new_file_check_interval", fmt.Errorf("Too small, try setting >= 1"))
+	}
+
+	if c.DebugLogMaxSize < 0 {
+		problems.add("debug_log_max_size", fmt.Errorf("Must not be negative"))
+	}
+
+	if c.DebugLogKeep < 0 {
+		problems.add("debug_log_keep", fmt.Errorf("Must not be negative"))
 	}
 
+	if c.TcpMaxLineLength < 0 {
+		problems.add("tcp_max_line_length", fmt.Errorf("Must not be negative"))
+	}
+
+	problems.add("health.listen", validHealthListen(c.Health.Listen))
+	if c.KeepReadCapability && !c.dropsPrivileges() {
+		problems.add("keep_read_capability", fmt.Errorf("Needs a user or group to switch to"))
+	}
+
+	problems.add("log_format", validLogFormat(c.LogFormat))
+	problems.add("forward_own_log.level", validOwnLogLevel(c.OwnLog.Level))
+	problems.add("dry_run_format", validDryRunFormat(c.DryRunFormat))
+	problems.add("on_truncate", validOnTruncate(c.OnTruncate))
+
+	if c.StateFile == "" && c.StartPosition.Mode == StartSaved {
+		problems.add("start_position", fmt.Errorf("saved needs a state_file"))
+	}
+
+	for i, lf := range c.Files {
+		key := fmt.Sprintf("files[%d]", i)
+
+		problems.add(key+".on_truncate", validOnTruncate(lf.OnTruncate))
+
+		if c.StateFile == "" && lf.StartPosition.Mode == StartSaved {
+			problems.add(key+".start_position", fmt.Errorf("saved needs a state_file"))
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
 	return nil
 }
 
@@ -284,9 +526,26 @@ func decodeRegexps(f interface{}) ([]*regexp.Regexp, error) {
 	return exps, nil
 }
 
+func decodeRegexp(f interface{}) (*regexp.Regexp, error) {
+	switch val := f.(type) {
+	case string:
+		return regexp.Compile(val)
+
+	// elements of a []*regexp.Regexp that decodeRegexps has already compiled
+	case *regexp.Regexp:
+		return val, nil
+
+	default:
+		return nil, fmt.Errorf("Invalid input type for regular expression %#v", f)
+	}
+}
+
+// decodeLogFiles decodes the files setting. Problems are reported as a
+// ConfigError, keyed by the entry they were found in.
 func decodeLogFiles(f interface{}) ([]LogFile, error) {
 	var (
-		files []LogFile
+		files    []LogFile
+		problems ConfigError
 	)
 
 	vals, ok := f.([]interface{})
@@ -294,7 +553,9 @@ func decodeLogFiles(f interface{}) ([]LogFile, error) {
 		return files, fmt.Errorf("Invalid input type for files: %#v", f)
 	}
 
-	for _, v := range vals {
+	for i, v := range vals {
+		key := fmt.Sprintf("files[%d]", i)
+
 		switch val := v.(type) {
 		case string:
 			lf := strings.Split(val, "=")
@@ -304,32 +565,134 @@ func decodeLogFiles(f interface{}) ([]LogFile, error) {
 			case 1:
 				files = append(files, LogFile{Path: val})
 			default:
-				return files, fmt.Errorf("Invalid log file name %s", val)
+				problems.add(key, fmt.Errorf("Invalid log file name %s", val))
 			}
 
-		case map[interface{}]interface{}:
-			var (
-				tag  string
-				path string
-			)
-
-			tag, _ = val["tag"].(string)
-			path, _ = val["path"].(string)
+		case map[interface{}]interface{}, map[string]interface{}:
+			var lf LogFile
+			entryProblems := decodeSettings(key, stringKeys(val), &lf)
+			problems = append(problems, entryProblems...)
 
-			if path == "" {
-				return files, fmt.Errorf("Invalid log file %#v", val)
+			if lf.Path == "" {
+				problems.add(key+".path", fmt.Errorf("A path is required"))
+				continue
 			}
 
-			files = append(files, LogFile{Tag: tag, Path: path})
+			if entryProblems.errors() == nil {
+				files = append(files, lf)
+			}
 
 		default:
-			panic(vals)
+			problems.add(key, fmt.Errorf("Invalid log file %#v, expected a path or settings", v))
 		}
 	}
 
+	if len(problems) > 0 {
+		return files, problems
+	}
 	return files, nil
 }
 
+var captureRef = regexp.MustCompile(`%\{(\w+)\}`)
+
+// forFile returns a copy of lf for a file its glob matched, with %{name}
+// references in the tag and hostname replaced by the path_pattern's
+// captures. References to captures that didn't match are left empty.
+func (lf LogFile) forFile(file string) LogFile {
+	if lf.PathPattern == nil {
+		return lf
+	}
+
+	m := lf.PathPattern.FindStringSubmatch(file)
+	if m == nil {
+		log.Debugf("%s doesn't match path_pattern %s", file, lf.PathPattern)
+	}
+
+	expand := func(template string) string {
+		return captureRef.ReplaceAllStringFunc(template, func(ref string) string {
+			name := captureRef.FindStringSubmatch(ref)[1]
+
+			i := lf.PathPattern.SubexpIndex(name)
+			if n, err := strconv.Atoi(name); err == nil {
+				i = n
+			}
+
+			if i < 0 || i >= len(m) {
+				return ""
+			}
+			return m[i]
+		})
+	}
+
+	lf.Tag = expand(lf.Tag)
+	lf.Hostname = expand(lf.Hostname)
+
+	if lf.Fields != nil {
+		fields := make(map[string]string, len(lf.Fields))
+		for name, value := range lf.Fields {
+			fields[name] = expand(value)
+		}
+		lf.Fields = fields
+	}
+
+	return lf
+}
+
+var envRef = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expandEnv replaces ${VAR} references with the value of the environment
+// variable VAR, warning about any that aren't set
+func expandEnv(value string) string {
+	return envRef.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			log.Warningf("Environment variable %s is not set", name)
+		}
+		return v
+	})
+}
+
+func decodeFields(f interface{}) (map[string]string, error) {
+	fields := make(map[string]string)
+
+	add := func(name, value interface{}) error {
+		n := strings.ToLower(fmt.Sprint(name))
+		if !syslog.ValidFieldName(n) {
+			return fmt.Errorf("Invalid field name %q", n)
+		}
+
+		fields[n] = expandEnv(fmt.Sprint(value))
+		return nil
+	}
+
+	switch val := f.(type) {
+	case map[interface{}]interface{}:
+		for name, value := range val {
+			if err := add(name, value); err != nil {
+				return nil, err
+			}
+		}
+
+	case map[string]interface{}:
+		for name, value := range val {
+			if err := add(name, value); err != nil {
+				return nil, err
+			}
+		}
+
+	// already decoded
+	case map[string]string:
+		return val, nil
+
+	default:
+		return nil, fmt.Errorf("Invalid input type for fields: %#v", f)
+	}
+
+	return fields, nil
+}
+
 func decodePriority(p interface{}) (interface{}, error) {
 	ps, ok := p.(string)
 	if !ok {
@@ -352,10 +715,21 @@ func decodePriority(p interface{}) (interface{}, error) {
 
 func decodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
 	switch to {
-	case reflect.TypeOf([]LogFile{}):
-		return decodeLogFiles(data)
 	case reflect.TypeOf([]*regexp.Regexp{}):
 		return decodeRegexps(data)
+	case reflect.TypeOf(&regexp.Regexp{}):
+		return decodeRegexp(data)
+	case reflect.TypeOf([]*RedactRule{}):
+		return decodeRedactRules(data)
+	case reflect.TypeOf([]string{}):
+		// a single value can be given instead of a list
+		if s, ok := data.(string); ok {
+			return []string{s}, nil
+		}
+	case reflect.TypeOf(map[string]string{}):
+		return decodeFields(data)
+	case reflect.TypeOf(StartPosition{}):
+		return decodeStartPosition(data)
 	case reflect.TypeOf(syslog.Priority(0)):
 		return decodePriority(data)
 	case reflect.TypeOf(time.Duration(0)):
@@ -396,6 +770,12 @@ func getPidFile() string {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s %s:\n", envPrefix, Version)
+	fmt.Fprintf(os.Stderr, "  %s [flags] [FILE...]\n", envPrefix)
+	fmt.Fprintf(os.Stderr, "  %s send [flags] FILE...  Send whole files, then exit\n", envPrefix)
+	fmt.Fprintf(os.Stderr, "  %s check-config [flags] [FILE...]  Check the configuration and list the files it matches\n", envPrefix)
+	fmt.Fprintf(os.Stderr, "  %s stop [flags]  Stop the daemon named by the pid file\n", envPrefix)
+	fmt.Fprintf(os.Stderr, "  %s restart [flags] [FILE...]  Stop the daemon, if running, and start a new one\n", envPrefix)
+	fmt.Fprintf(os.Stderr, "  %s reload [flags]  Check the configuration and have the daemon reload it\n\n", envPrefix)
 	flags.PrintDefaults()
 }
 
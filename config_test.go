@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"regexp"
 	"testing"
 	"time"
@@ -13,6 +14,9 @@ func TestRawConfig(t *testing.T) {
 	assert := assert.New(t)
 	initConfigAndFlags()
 
+	os.Setenv("RS_TEST_REGION", "eu-west-1")
+	defer os.Unsetenv("RS_TEST_REGION")
+
 	// pretend like some things were passed on the command line
 	flags.Set("configfile", "test/config.yaml")
 	flags.Set("tls", "true")
@@ -28,6 +32,8 @@ func TestRawConfig(t *testing.T) {
 	assert.Equal(c.Destination.Token, "0123456789-ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz")
 	assert.Equal(c.ExcludePatterns, []*regexp.Regexp{regexp.MustCompile("don't log on me"), regexp.MustCompile(`do \w+ on me`)})
 	assert.Equal(c.ExcludeFiles, []*regexp.Regexp{regexp.MustCompile(`\.DS_Store`)})
+	assert.Equal(c.IncludePatterns, []*regexp.Regexp{regexp.MustCompile("ERROR")})
+	assert.Equal(c.IncludeFiles, []*regexp.Regexp{regexp.MustCompile(`\.(log|txt)$`)})
 	assert.Equal(c.Files, []LogFile{
 		{
 			Path: "locallog.txt",
@@ -42,6 +48,43 @@ func TestRawConfig(t *testing.T) {
 		{
 			Tag:  "apache",
 			Path: "/var/log/httpd/access_log",
+			RateLimit: RateLimit{
+				LinesPerSecond: 50,
+				BytesPerSecond: 10000,
+				Delay:          true,
+			},
+			Dedup: Dedup{
+				Window:     30 * time.Second,
+				MaskDigits: true,
+			},
+			Redact: []*RedactRule{
+				{
+					Name:        "email",
+					Pattern:     regexp.MustCompile(redactDetectors["email"].pattern),
+					Replacement: "[REDACTED]",
+					Detector:    "email",
+				},
+			},
+			IncludePatterns: []*regexp.Regexp{regexp.MustCompile("GET")},
+			StartPosition:   StartPosition{Mode: StartLastLines, Count: 10},
+			OnTruncate:      TruncateWarn,
+		},
+		{
+			Path:        "/var/log/apps/*/current",
+			PathPattern: regexp.MustCompile(`/var/log/apps/(?P<app>[^/]+)/current`),
+			Tag:         "%{app}",
+			Hostname:    "%{app}.example.com",
+			Fields:      map[string]string{"app": "%{app}", "role": "worker"},
+		},
+	})
+	assert.Equal(c.Fields, map[string]string{"environment": "production", "region": "eu-west-1"})
+	assert.Equal(c.RateLimit, RateLimit{LinesPerSecond: 500, LineBurst: 1000})
+	assert.Equal(c.RateLimitSummary, 30*time.Second)
+	assert.Equal(c.Redact, []*RedactRule{
+		{
+			Name:        "password",
+			Pattern:     regexp.MustCompile(`password=\S+`),
+			Replacement: "password=***",
 		},
 	})
 	assert.Equal(c.TcpMaxLineLength, 99991)
@@ -52,6 +95,9 @@ func TestRawConfig(t *testing.T) {
 	assert.Equal(c.TLS, true)
 	assert.Equal(c.LogLevels, "<root>=INFO")
 	assert.Equal(c.PidFile, "/var/run/rs2.pid")
+	assert.Equal(c.StateFile, "/var/lib/rs2/offsets.json")
+	assert.Equal(c.StartPosition, StartPosition{Mode: StartSaved})
+	assert.Equal(c.OnTruncate, TruncateRestart)
 	assert.Equal(c.DebugLogFile, "/dev/null")
 	assert.Equal(c.NoDetach, false)
 	sev, err := syslog.Severity("notice")
@@ -86,3 +132,121 @@ func TestNoConfigFile(t *testing.T) {
 	assert.Equal("udp", c.Destination.Protocol)
 	assert.Equal("", c.Destination.Token)
 }
+
+func TestValidateSavedStartPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Config{NewFileCheckInterval: time.Second}
+	c.Destination.Host = "localhost"
+	c.Files = []LogFile{{Path: "a.log", StartPosition: StartPosition{Mode: StartSaved}}}
+	assert.Error(c.Validate())
+
+	c.StateFile = "offsets.json"
+	assert.NoError(c.Validate())
+}
+
+func TestValidateProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	// left out, it's udp, as when loaded
+	c := &Config{NewFileCheckInterval: time.Second}
+	c.Destination.Host = "localhost"
+	assert.NoError(c.Validate())
+	assert.Equal("udp", c.Destination.Protocol)
+
+	c.Destination.Protocol = "http"
+	assert.Error(c.Validate())
+}
+
+func TestValidateOnTruncate(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Config{NewFileCheckInterval: time.Second, OnTruncate: TruncateWarn}
+	c.Destination.Host = "localhost"
+	c.Files = []LogFile{{Path: "a.log", OnTruncate: TruncateRestart}}
+	assert.NoError(c.Validate())
+
+	c.Files[0].OnTruncate = "ignore"
+	assert.Error(c.Validate())
+
+	c.Files[0].OnTruncate = ""
+	c.OnTruncate = "ignore"
+	assert.Error(c.Validate())
+}
+
+func TestValidateDryRun(t *testing.T) {
+	assert := assert.New(t)
+
+	// nothing is sent, so no destination is needed
+	c := &Config{NewFileCheckInterval: time.Second, DryRun: true}
+	assert.NoError(c.Validate())
+
+	c.DryRunFormat = DryRunJSON
+	assert.NoError(c.Validate())
+
+	c.DryRunFormat = "xml"
+	assert.Error(c.Validate())
+}
+
+func TestValidateKeepReadCapability(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Config{NewFileCheckInterval: time.Second, DryRun: true, KeepReadCapability: true}
+	if err, ok := c.Validate().(ConfigError); assert.True(ok) && assert.Len(err, 1) {
+		assert.Equal("keep_read_capability", err[0].Key)
+	}
+
+	c.User = "nobody"
+	assert.NoError(c.Validate())
+}
+
+func TestLogFileCaptures(t *testing.T) {
+	assert := assert.New(t)
+
+	lf := LogFile{
+		Path:        "/var/log/apps/*/*.log",
+		PathPattern: regexp.MustCompile(`/var/log/apps/(?P<app>[^/]+)/(\w+)\.log`),
+		Tag:         "%{app}/%{2}",
+		Hostname:    "%{app}-%{missing}.internal",
+	}
+
+	resolved := lf.forFile("/var/log/apps/billing/worker.log")
+	assert.Equal("billing/worker", resolved.Tag)
+	assert.Equal("billing-.internal", resolved.Hostname)
+	assert.Equal("%{app}/%{2}", lf.Tag, "Expected the glob's own entry to be unchanged")
+
+	// paths that don't match leave the references empty
+	resolved = lf.forFile("/somewhere/else.log")
+	assert.Equal("/", resolved.Tag)
+
+	// captures are expanded in field values too, without touching the
+	// glob's own map
+	lf.Fields = map[string]string{"app": "%{app}", "env": "prod"}
+	resolved = lf.forFile("/var/log/apps/billing/worker.log")
+	assert.Equal(map[string]string{"app": "billing", "env": "prod"}, resolved.Fields)
+	assert.Equal("%{app}", lf.Fields["app"])
+
+	// without a path_pattern the templates are kept as they are
+	lf = LogFile{Path: "/var/log/*.log", Tag: "%{app}"}
+	assert.Equal("%{app}", lf.forFile("/var/log/a.log").Tag)
+}
+
+func TestDecodeFields(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("RS_TEST_CLUSTER", "blue")
+	defer os.Unsetenv("RS_TEST_CLUSTER")
+
+	fields, err := decodeFields(map[interface{}]interface{}{
+		"cluster": "${RS_TEST_CLUSTER}-${RS_TEST_UNSET}",
+		"Shard":   7,
+	})
+	assert.NoError(err)
+	assert.Equal(map[string]string{"cluster": "blue-", "shard": "7"}, fields)
+
+	_, err = decodeFields(map[interface{}]interface{}{"bad name": "x"})
+	assert.Error(err)
+
+	_, err = decodeFields([]interface{}{"x"})
+	assert.Error(err)
+}
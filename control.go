@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/utils"
+)
+
+// how much longer than its shutdown timeout stop waits for the daemon to
+// exit before killing it
+const stopGrace = 5 * time.Second
+
+// Control runs the stop, restart and reload subcommands, which find the
+// daemon through the pid file. It reports whether remote_syslog should
+// carry on and start, as it does after restart stops the old daemon.
+func (c *Config) Control() (bool, error) {
+	switch c.Command {
+	case "stop":
+		return false, c.stopDaemon()
+
+	case "reload":
+		// the daemon would keep its old configuration rather than take a
+		// broken one, so say why here
+		if err := c.Validate(); err != nil {
+			return false, err
+		}
+		if err := utils.ReloadDaemon(c.PidFile); err != nil {
+			return false, err
+		}
+		fmt.Println("Reloading")
+		return false, nil
+
+	case "restart":
+		// the daemon Start detaches runs with the same arguments, and
+		// mustn't stop anything itself
+		if utils.Detached() {
+			return true, nil
+		}
+
+		// don't stop the old daemon unless the new one can start
+		if err := c.Validate(); err != nil {
+			return false, err
+		}
+		return true, c.stopDaemon()
+	}
+
+	return true, nil
+}
+
+func (c *Config) stopDaemon() error {
+	err := utils.StopDaemon(c.PidFile, c.ShutdownTimeout+stopGrace)
+	switch err {
+	case nil:
+		fmt.Println("Stopped")
+	case utils.ErrNotRunning:
+		fmt.Println("Not running")
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindDaemon(t *testing.T) {
+	assert := assert.New(t)
+
+	pidFile := tmpdir + "/find.pid"
+	defer os.Remove(pidFile)
+
+	writePid := func(pid int) {
+		ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", pid)), 0644)
+	}
+	removed := func() bool {
+		_, err := os.Stat(pidFile)
+		return os.IsNotExist(err)
+	}
+
+	_, err := utils.FindDaemon(pidFile)
+	assert.Equal(utils.ErrNotRunning, err)
+
+	// this test binary stands in for a running remote_syslog
+	writePid(os.Getpid())
+	if p, err := utils.FindDaemon(pidFile); assert.NoError(err) {
+		assert.Equal(os.Getpid(), p.Pid)
+	}
+
+	// a process that has exited
+	cmd := exec.Command("true")
+	cmd.Run()
+	writePid(cmd.Process.Pid)
+	_, err = utils.FindDaemon(pidFile)
+	assert.Equal(utils.ErrNotRunning, err)
+	assert.True(removed())
+
+	// a process that isn't remote_syslog, as after its pid is reused
+	cmd = exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	writePid(cmd.Process.Pid)
+	_, err = utils.FindDaemon(pidFile)
+	assert.Equal(utils.ErrNotRunning, err)
+	assert.True(removed())
+}
+
+func TestStopDaemon(t *testing.T) {
+	assert := assert.New(t)
+
+	pidFile := tmpdir + "/stop.pid"
+	defer os.Remove(pidFile)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperDaemon$")
+	cmd.Env = append(os.Environ(), "REMOTE_SYSLOG_HELPER_DAEMON=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0644)
+
+	assert.NoError(utils.StopDaemon(pidFile, 5*time.Second))
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Error("Expected the daemon to have exited")
+	}
+	_, err := os.Stat(pidFile)
+	assert.True(os.IsNotExist(err))
+
+	assert.Equal(utils.ErrNotRunning, utils.StopDaemon(pidFile, 5*time.Second))
+}
+
+// TestHelperDaemon isn't a test, but a process for TestStopDaemon to stop
+func TestHelperDaemon(t *testing.T) {
+	if os.Getenv("REMOTE_SYSLOG_HELPER_DAEMON") == "" {
+		t.Skip("Only run by TestStopDaemon")
+	}
+	time.Sleep(time.Minute)
+}
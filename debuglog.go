@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// how many rotated debug logs are kept if not configured
+const defaultDebugLogKeep = 5
+
+// debugLog is the file the daemon's output is copied to. It can be
+// reopened, after logrotate has moved it away, and rotates itself once it
+// grows past maxSize, keeping path.1 to path.keep. Only regular files are
+// rotated, so the default of /dev/null is left alone. It is safe for
+// concurrent use.
+type debugLog struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	regular bool
+}
+
+func openDebugLog(path string, maxSize int64, keep int) (*debugLog, error) {
+	l := &debugLog{path: path, maxSize: maxSize, keep: keep}
+	if l.keep <= 0 {
+		l.keep = defaultDebugLogKeep
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file for appending. It must be called with mu held, or
+// before l is shared.
+func (l *debugLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f, l.size, l.regular = f, fi.Size(), fi.Mode().IsRegular()
+	return nil
+}
+
+func (l *debugLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.regular && l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			// carry on writing to the file we have, rather than lose output
+			fmt.Fprintf(l.f, "Failed to rotate %s: %s\n", l.path, err)
+		}
+	}
+
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames path.N to path.N+1, dropping the oldest, and path to
+// path.1, then starts a new file
+func (l *debugLog) rotate() error {
+	for i := l.keep - 1; i > 0; i-- {
+		old := fmt.Sprintf("%s.%d", l.path, i)
+		if err := os.Rename(old, fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+
+	return l.reopen()
+}
+
+// Reopen closes the file and opens path again, so output goes to a new
+// file once logrotate has moved the old one away
+func (l *debugLog) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.reopen()
+}
+
+func (l *debugLog) reopen() error {
+	old := l.f
+	if err := l.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (l *debugLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.f.Close()
+}
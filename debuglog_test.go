@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugLogRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	path := tmpdir + "/debug.log"
+	defer func() {
+		for _, name := range []string{path, path + ".1", path + ".2", path + ".3"} {
+			os.Remove(name)
+		}
+	}()
+
+	l, err := openDebugLog(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// each write past 10 bytes starts a new file, and only 2 old ones are
+	// kept
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		l.Write([]byte(line))
+	}
+
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(name)
+		return string(data)
+	}
+
+	assert.Equal("fourth\n", read(path))
+	assert.Equal("third\n", read(path+".1"))
+	assert.Equal("second\n", read(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(os.IsNotExist(err))
+}
+
+func TestDebugLogReopen(t *testing.T) {
+	assert := assert.New(t)
+
+	path := tmpdir + "/reopen.log"
+	defer os.Remove(path)
+	defer os.Remove(path + ".rotated")
+
+	l, err := openDebugLog(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Write([]byte("before\n"))
+
+	// as logrotate would
+	os.Rename(path, path+".rotated")
+	l.Write([]byte("still old\n"))
+
+	assert.NoError(l.Reopen())
+	l.Write([]byte("after\n"))
+
+	data, _ := ioutil.ReadFile(path + ".rotated")
+	assert.Equal("before\nstill old\n", string(data))
+
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal("after\n", string(data))
+}
+
+func TestDebugLogDevNull(t *testing.T) {
+	l, err := openDebugLog(os.DevNull, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// only regular files are rotated
+	l.Write([]byte("one\n"))
+	l.Write([]byte("two\n"))
+	_, err = os.Stat(os.DevNull + ".1")
+	assert.True(t, os.IsNotExist(err))
+}
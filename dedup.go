@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var digits = regexp.MustCompile(`\d+`)
+
+// Dedup configures collapsing of consecutive identical lines. Repeats seen
+// within Window of the first occurrence are dropped and reported with a
+// single "last message repeated N times" message, like syslogd does.
+type Dedup struct {
+	Window time.Duration `mapstructure:"window"`
+
+	// MaskDigits treats lines that only differ in their digits as
+	// identical, so timestamps and counters don't defeat deduplication
+	MaskDigits bool `mapstructure:"mask_digits"`
+}
+
+// A deduper tracks repeats of the last forwarded line of one file. It is
+// not safe for concurrent use.
+type deduper struct {
+	window  time.Duration
+	mask    bool
+	last    string
+	first   time.Time
+	repeats int
+}
+
+// newDeduper returns nil if d doesn't enable deduplication
+func newDeduper(d Dedup) *deduper {
+	if d.Window <= 0 {
+		return nil
+	}
+
+	return &deduper{window: d.Window, mask: d.MaskDigits}
+}
+
+func (d *deduper) key(msg string) string {
+	if d.mask {
+		return digits.ReplaceAllString(msg, "0")
+	}
+	return msg
+}
+
+// check decides whether msg should be forwarded. If it ends a run of
+// repeats, the summary for them is returned so it can be sent first.
+func (d *deduper) check(msg string, now time.Time) (forward bool, summary string) {
+	key := d.key(msg)
+	if key == d.last && now.Sub(d.first) < d.window {
+		d.repeats++
+		return false, ""
+	}
+
+	summary = d.summary()
+	d.last = key
+	d.first = now
+	d.repeats = 0
+
+	return true, summary
+}
+
+// flush returns the summary for repeats whose window has passed, so they
+// are reported even if the file goes quiet. The next occurrence of the line
+// is forwarded again.
+func (d *deduper) flush(now time.Time) string {
+	if d.repeats == 0 || now.Sub(d.first) < d.window {
+		return ""
+	}
+
+	return d.end()
+}
+
+// end returns the summary for any pending repeats and resets the run
+func (d *deduper) end() string {
+	summary := d.summary()
+	d.last = ""
+	d.repeats = 0
+
+	return summary
+}
+
+func (d *deduper) summary() string {
+	switch d.repeats {
+	case 0:
+		return ""
+	case 1:
+		return "last message repeated 1 time"
+	default:
+		return fmt.Sprintf("last message repeated %d times", d.repeats)
+	}
+}
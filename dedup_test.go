@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newDeduper(Dedup{}))
+
+	d := newDeduper(Dedup{Window: 10 * time.Second})
+	now := time.Now()
+
+	forward, summary := d.check("connection refused", now)
+	assert.True(forward)
+	assert.Equal("", summary)
+
+	for i := 0; i < 3; i++ {
+		forward, summary = d.check("connection refused", now.Add(time.Second))
+		assert.False(forward)
+		assert.Equal("", summary)
+	}
+
+	// a different line ends the run
+	forward, summary = d.check("connected", now.Add(2*time.Second))
+	assert.True(forward)
+	assert.Equal("last message repeated 3 times", summary)
+
+	forward, summary = d.check("connected", now.Add(3*time.Second))
+	assert.False(forward)
+	assert.Equal("", d.flush(now.Add(4*time.Second)), "Expected no summary inside the window")
+	assert.Equal("last message repeated 1 time", d.flush(now.Add(12*time.Second)))
+
+	// after a flush the line is forwarded again
+	forward, summary = d.check("connected", now.Add(13*time.Second))
+	assert.True(forward)
+	assert.Equal("", summary)
+	assert.Equal("", d.end())
+}
+
+func TestDedupMaskDigits(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+
+	d := newDeduper(Dedup{Window: time.Minute})
+	d.check("retry 1 of 10", now)
+	forward, _ := d.check("retry 2 of 10", now)
+	assert.True(forward, "Expected digits to matter without mask_digits")
+
+	d = newDeduper(Dedup{Window: time.Minute, MaskDigits: true})
+	d.check("retry 1 of 10", now)
+	forward, _ = d.check("retry 2 of 10", now)
+	assert.False(forward, "Expected digits to be masked")
+	assert.Equal("last message repeated 1 time", d.end())
+}
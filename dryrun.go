@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/syslog"
+)
+
+// Formats a dry run can print packets in
+const (
+	DryRunRFC5424 = "rfc5424"
+	DryRunJSON    = "json"
+)
+
+// where a dry run prints to, replaced in tests
+var dryRunOutput io.Writer = os.Stdout
+
+func validDryRunFormat(format string) error {
+	switch format {
+	case "", DryRunRFC5424, DryRunJSON:
+		return nil
+	default:
+		return fmt.Errorf("Invalid dry_run_format %q, must be %s or %s", format, DryRunRFC5424, DryRunJSON)
+	}
+}
+
+// A verdict is what was done with a line or file, and the rule that
+// decided it, if any
+type verdict struct {
+	action string
+	rule   string
+}
+
+// lineVerdict describes what filterLine decided about a line
+func lineVerdict(forward bool, exp *regexp.Regexp) verdict {
+	switch {
+	case forward && exp != nil:
+		return verdict{"forwarded", ruleString("include_patterns", exp)}
+	case forward:
+		return verdict{"forwarded", ""}
+	case exp != nil:
+		return verdict{"excluded", ruleString("exclude_patterns", exp)}
+	default:
+		return verdict{"excluded", "no include_patterns match"}
+	}
+}
+
+func ruleString(setting string, exp *regexp.Regexp) string {
+	return fmt.Sprintf("%s %q", setting, exp.String())
+}
+
+// A dryRun prints the packets that would be sent instead of sending them,
+// along with the lines and files that were left out, each annotated with
+// the file it came from and the rule that decided. It is safe for
+// concurrent use.
+type dryRun struct {
+	mu      sync.Mutex
+	w       io.Writer
+	json    bool
+	maxSize int
+
+	// files already reported as skipped, since the globs are checked
+	// over and over
+	skipped map[string]bool
+}
+
+// the JSON format: packet is set for what would be sent, line for what
+// wouldn't
+type dryRunEntry struct {
+	File   string        `json:"file"`
+	Action string        `json:"action"`
+	Rule   string        `json:"rule,omitempty"`
+	Packet *dryRunPacket `json:"packet,omitempty"`
+	Line   string        `json:"line,omitempty"`
+}
+
+type dryRunPacket struct {
+	Time     string          `json:"time"`
+	Hostname string          `json:"hostname"`
+	Tag      string          `json:"tag"`
+	Severity syslog.Priority `json:"severity"`
+	Facility syslog.Priority `json:"facility"`
+	Message  string          `json:"message"`
+}
+
+func newDryRun(w io.Writer, c *Config) *dryRun {
+	// packets are cut to the length the protocol would send
+	maxSize := c.TcpMaxLineLength
+	if c.Destination.Protocol == "udp" {
+		maxSize = 1024
+	}
+
+	return &dryRun{
+		w:       w,
+		json:    c.DryRunFormat == DryRunJSON,
+		maxSize: maxSize,
+		skipped: make(map[string]bool),
+	}
+}
+
+// packet prints a packet that would be sent from file
+func (d *dryRun) packet(file string, v verdict, p syslog.Packet) {
+	if !d.json {
+		d.print(file, v, p.Generate(d.maxSize))
+		return
+	}
+
+	// the packet's fields are keys of their own, giving way to the
+	// entry's if the names clash
+	record := make(map[string]interface{}, len(p.Fields)+4)
+	for name, value := range p.Fields {
+		record[name] = value
+	}
+	record["file"] = file
+	record["action"] = v.action
+	if v.rule != "" {
+		record["rule"] = v.rule
+	}
+	record["packet"] = &dryRunPacket{
+		Time:     p.Time.Format(time.RFC3339Nano),
+		Hostname: p.Hostname,
+		Tag:      p.Tag,
+		Severity: p.Severity,
+		Facility: p.Facility,
+		Message:  p.Message,
+	}
+	d.printJSON(file, record)
+}
+
+// line prints a line from file that wouldn't be sent
+func (d *dryRun) line(file string, v verdict, l string) {
+	if d.json {
+		d.printJSON(file, dryRunEntry{File: file, Action: v.action, Rule: v.rule, Line: l})
+	} else {
+		d.print(file, v, l)
+	}
+}
+
+// skip prints a file the globs matched that won't be read, the first time
+// it is skipped
+func (d *dryRun) skip(file, rule string) {
+	d.mu.Lock()
+	seen := d.skipped[file]
+	d.skipped[file] = true
+	d.mu.Unlock()
+
+	if seen {
+		return
+	}
+
+	v := verdict{"skipped", rule}
+	if d.json {
+		d.printJSON(file, dryRunEntry{File: file, Action: v.action, Rule: v.rule})
+	} else {
+		d.print(file, v, "")
+	}
+}
+
+func (d *dryRun) print(file string, v verdict, text string) {
+	prefix := file + " " + v.action
+	if v.rule != "" {
+		prefix += " (" + v.rule + ")"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if text == "" {
+		fmt.Fprintln(d.w, prefix)
+	} else {
+		fmt.Fprintf(d.w, "%s: %s\n", prefix, maskSecrets(text))
+	}
+}
+
+func (d *dryRun) printJSON(file string, e interface{}) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Failed to print %s: %s", file, err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.w, "%s\n", data)
+}
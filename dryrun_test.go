@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/papertrail/remote_syslog2/syslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpLogFile()
+	defer file.Close()
+
+	writeLog(file, "ERROR disk full")
+	writeLog(file, "ERROR healthcheck failed")
+	writeLog(file, "INFO all good")
+
+	var out bytes.Buffer
+	defer func(w io.Writer) { dryRunOutput = w }(dryRunOutput)
+	dryRunOutput = &out
+
+	config := testConfig()
+	config.DryRun = true
+	config.Destination.Host = ""
+	config.ExcludePatterns = []*regexp.Regexp{regexp.MustCompile("healthcheck")}
+	config.IncludePatterns = []*regexp.Regexp{regexp.MustCompile("ERROR")}
+
+	s := NewServer(config)
+	_, err := s.Send([]string{file.Name()})
+	assert.NoError(err)
+	assert.Nil(s.logger, "Expected a dry run not to connect")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(lines, 3) {
+		return
+	}
+
+	prefix := file.Name() + ` forwarded (include_patterns "ERROR"): `
+	if assert.True(strings.HasPrefix(lines[0], prefix), lines[0]) {
+		packet, err := syslog.Parse(strings.TrimPrefix(lines[0], prefix))
+		assert.NoError(err)
+		assert.Equal("ERROR disk full", packet.Message)
+		assert.Equal("testhost", packet.Hostname)
+	}
+
+	assert.Equal(file.Name()+` excluded (exclude_patterns "healthcheck"): ERROR healthcheck failed`, lines[1])
+	assert.Equal(file.Name()+` excluded (no include_patterns match): INFO all good`, lines[2])
+}
+
+func TestDryRunJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	d := newDryRun(&out, &Config{DryRunFormat: DryRunJSON, TcpMaxLineLength: 99990})
+
+	d.packet("app.log", verdict{"forwarded", ""}, syslog.Packet{
+		Tag:     "app",
+		Message: "hello",
+		Fields:  map[string]string{"env": "prod", "file": "clash"},
+	})
+	d.line("app.log", verdict{"repeated", "dedup"}, "hello")
+	d.skip("debug.log", `exclude_files "debug"`)
+	d.skip("debug.log", `exclude_files "debug"`)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var entries []dryRunEntry
+	for _, l := range lines {
+		var e dryRunEntry
+		assert.NoError(json.Unmarshal([]byte(l), &e), l)
+		entries = append(entries, e)
+	}
+
+	// a skipped file is only printed once
+	if !assert.Len(entries, 3) {
+		return
+	}
+
+	if assert.NotNil(entries[0].Packet) {
+		assert.Equal("hello", entries[0].Packet.Message)
+		assert.Equal("app", entries[0].Packet.Tag)
+	}
+
+	// fields are keys of their own, except where they clash
+	var record map[string]interface{}
+	assert.NoError(json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal("prod", record["env"])
+	assert.Equal("app.log", record["file"])
+	assert.NotContains(record, "fields")
+	assert.Equal(dryRunEntry{File: "app.log", Action: "repeated", Rule: "dedup", Line: "hello"}, entries[1])
+	assert.Equal(dryRunEntry{File: "debug.log", Action: "skipped", Rule: `exclude_files "debug"`}, entries[2])
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+)
+
+// how many bytes from the start of a file identify it
+const fingerprintSize = 1024
+
+// A fingerprint identifies a file's contents by a hash of its first bytes,
+// so they can be recognized after log rotation renames or compresses the
+// file. Files shorter than fingerprintSize are identified by all of their
+// bytes, so a fingerprint only matches files starting with the same Size
+// bytes.
+type fingerprint struct {
+	Size int    `json:"size"`
+	Sum  string `json:"sum"`
+}
+
+func newFingerprint(head []byte) fingerprint {
+	if len(head) > fingerprintSize {
+		head = head[:fingerprintSize]
+	}
+	if len(head) == 0 {
+		return fingerprint{}
+	}
+
+	sum := sha1.Sum(head)
+	return fingerprint{Size: len(head), Sum: hex.EncodeToString(sum[:])}
+}
+
+// empty reports whether the fingerprint was taken from an empty file, and
+// so can't identify it
+func (f fingerprint) empty() bool {
+	return f.Size == 0
+}
+
+// matches reports whether a file starting with head has the contents the
+// fingerprint was taken from
+func (f fingerprint) matches(head []byte) bool {
+	if f.empty() || len(head) < f.Size {
+		return false
+	}
+	return newFingerprint(head[:f.Size]) == f
+}
+
+// readHead returns the first fingerprintSize bytes of a file, decompressed
+func readHead(file string) ([]byte, error) {
+	r, err := openLog(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	head := make([]byte, fingerprintSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	long := []byte(strings.Repeat("0123456789", 200))
+	fp := newFingerprint(long)
+	assert.Equal(fingerprintSize, fp.Size)
+	assert.True(fp.matches(long))
+	assert.True(fp.matches(long[:fingerprintSize]))
+	assert.False(fp.matches(long[:fingerprintSize-1]))
+	assert.False(fp.matches(append([]byte("x"), long...)))
+
+	short := newFingerprint([]byte("one line\n"))
+	assert.Equal(9, short.Size)
+	assert.True(short.matches([]byte("one line\ntwo lines\n")))
+	assert.False(short.matches([]byte("one")))
+
+	empty := newFingerprint(nil)
+	assert.True(empty.empty())
+	assert.False(empty.matches(nil))
+	assert.False(empty.matches(long))
+}
@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/VividCortex/godaemon v1.0.0
 	github.com/howbazaar/loggo v0.0.0-20131030201820-384be4108823
+	github.com/klauspost/compress v1.15.15
 	github.com/mitchellh/gox v1.0.1
 	github.com/mitchellh/mapstructure v0.0.0-20160808181253-ca63d7c062ee
 	github.com/nightlyone/lockfile v0.0.0-20160306143149-b30dcbfa86e3
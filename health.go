@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/syslog"
+)
+
+// Defaults for the readiness thresholds that aren't configured
+const (
+	defaultMaxDisconnected = 60 * time.Second
+	defaultMaxStalled      = 5 * time.Minute
+	defaultMaxQueued       = syslog.DefaultQueueSize * 3 / 4
+)
+
+// how long the queue has to stay at max_queued or over before the agent
+// isn't ready, so a burst of lines filling it doesn't fail the check
+const queueBackedUp = 30 * time.Second
+
+// Health configures the optional HTTP listener serving /healthz, /readyz
+// and expvar's /debug/vars, for orchestrators to probe
+type Health struct {
+	Listen string
+
+	// MaxDisconnected is how long the destination can be unreachable
+	// before the agent isn't ready
+	MaxDisconnected time.Duration `mapstructure:"max_disconnected"`
+
+	// MaxStalled is how long a file can have unread lines without any of
+	// them being forwarded
+	MaxStalled time.Duration `mapstructure:"max_stalled"`
+
+	// MaxQueued is how many packets can be waiting to be sent
+	MaxQueued int `mapstructure:"max_queued"`
+}
+
+func (h Health) maxDisconnected() time.Duration {
+	if h.MaxDisconnected <= 0 {
+		return defaultMaxDisconnected
+	}
+	return h.MaxDisconnected
+}
+
+func (h Health) maxStalled() time.Duration {
+	if h.MaxStalled <= 0 {
+		return defaultMaxStalled
+	}
+	return h.MaxStalled
+}
+
+func (h Health) maxQueued() int {
+	if h.MaxQueued <= 0 {
+		return defaultMaxQueued
+	}
+	return h.MaxQueued
+}
+
+// validHealthListen checks the listen address can be listened on
+func validHealthListen(listen string) error {
+	if listen == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(listen); err != nil {
+		return fmt.Errorf("Invalid address %q, try host:port or :port", listen)
+	}
+	return nil
+}
+
+// The result of one health check
+type healthCheck struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// The body of /healthz and /readyz. The status is 200 when OK and 503
+// otherwise.
+type healthReport struct {
+	OK     bool                   `json:"ok"`
+	Checks map[string]healthCheck `json:"checks"`
+	Files  []fileHealth           `json:"files,omitempty"`
+}
+
+func (r *healthReport) check(name string, ok bool, format string, args ...interface{}) {
+	if r.Checks == nil {
+		r.Checks = make(map[string]healthCheck)
+	}
+	r.Checks[name] = healthCheck{OK: ok, Message: fmt.Sprintf(format, args...)}
+}
+
+func (r *healthReport) done() *healthReport {
+	r.OK = true
+	for _, c := range r.Checks {
+		r.OK = r.OK && c.OK
+	}
+	return r
+}
+
+// How far a file being tailed has been forwarded
+type fileHealth struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+
+	// Stalled is how long the file has had unread lines without any of
+	// them being forwarded
+	Stalled string `json:"stalled,omitempty"`
+}
+
+// progress tracks when each file's offset last moved, so files with lines
+// waiting that aren't being forwarded can be spotted. It only sees the
+// offsets it's shown, so a file is found stalled once the probes checking
+// it have spanned MaxStalled.
+type progress struct {
+	mu    sync.Mutex
+	files map[string]fileProgress
+}
+
+type fileProgress struct {
+	offset int64
+	since  time.Time
+}
+
+// stalled records a file's offset and size, returning how long it has had
+// lines waiting without its offset moving
+func (p *progress) stalled(file string, offset, size int64, now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.files == nil {
+		p.files = make(map[string]fileProgress)
+	}
+
+	last, ok := p.files[file]
+	if !ok || last.offset != offset || size <= offset {
+		p.files[file] = fileProgress{offset: offset, since: now}
+		return 0
+	}
+	return now.Sub(last.since)
+}
+
+// forget drops the files that are no longer being tailed
+func (p *progress) forget(keep []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tailed := make(map[string]bool, len(keep))
+	for _, file := range keep {
+		tailed[file] = true
+	}
+	for file := range p.files {
+		if !tailed[file] {
+			delete(p.files, file)
+		}
+	}
+}
+
+// serveHealth starts the health listener, if one is configured
+func (s *Server) serveHealth() {
+	s.mu.RLock()
+	listen := s.config.Health.Listen
+	s.mu.RUnlock()
+
+	if listen == "" {
+		return
+	}
+
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Errorf("Failed to listen for health checks on %s: %s", listen, err)
+		return
+	}
+	log.Infof("Serving health checks on %s", ln.Addr())
+
+	health := &http.Server{Handler: s.healthHandler()}
+	s.mu.Lock()
+	s.health = health
+	s.mu.Unlock()
+
+	go func() {
+		if err := health.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Health check listener failed: %s", err)
+		}
+	}()
+}
+
+// closeHealth stops the health listener
+func (s *Server) closeHealth() {
+	s.mu.RLock()
+	health := s.health
+	s.mu.RUnlock()
+
+	if health != nil {
+		health.Close()
+	}
+}
+
+func (s *Server) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, s.liveness())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, s.readiness(time.Now()))
+	})
+	mux.HandleFunc("/debug/vars", serveVars)
+	return mux
+}
+
+// serveVars serves the expvar vars like expvar.Handler, but leaves out
+// cmdline, which can hold the token, and masks secrets in the rest
+func serveVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, maskSecrets(kv.Value.String()))
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
+
+func writeHealth(w http.ResponseWriter, report *healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// liveness checks the process is responsive: that the server's lock can be
+// taken and that new files are still being looked for
+func (s *Server) liveness() *healthReport {
+	report := &healthReport{}
+
+	locked := make(chan struct{})
+	go func() {
+		s.mu.RLock()
+		s.mu.RUnlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		report.check("server", true, "%d goroutines", runtime.NumGoroutine())
+	case <-time.After(time.Second):
+		// nothing else can be checked without the lock
+		report.check("server", false, "Server lock not taken within a second")
+		return report.done()
+	}
+
+	s.mu.RLock()
+	lastGlob, interval := s.lastGlob, s.config.NewFileCheckInterval
+	s.mu.RUnlock()
+
+	// tailing pauses while reloading, so allow a few intervals
+	switch since := time.Since(lastGlob); {
+	case lastGlob.IsZero():
+		report.check("tailing", true, "Starting")
+	case since > 3*interval:
+		report.check("tailing", false, "Last checked for new files %s ago", since.Round(time.Second))
+	default:
+		report.check("tailing", true, "Last checked for new files %s ago", since.Round(time.Second))
+	}
+
+	return report.done()
+}
+
+// queueOver records whether the queue is at its limit or over, returning
+// since when it has been, or the zero time if it isn't
+func (s *Server) queueOver(over bool, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !over:
+		s.queuedOver = time.Time{}
+	case s.queuedOver.IsZero():
+		s.queuedOver = now
+	}
+	return s.queuedOver
+}
+
+// readiness checks the agent is forwarding: that the destination is
+// reachable, that the queue of packets to send isn't backing up, and that
+// no file has lines waiting that aren't being forwarded
+func (s *Server) readiness(now time.Time) *healthReport {
+	report := &healthReport{}
+
+	s.mu.RLock()
+	started, stopped, config, logger := s.started, s.stopped, s.config, s.logger
+	s.mu.RUnlock()
+
+	switch {
+	case stopped:
+		report.check("server", false, "Shutting down")
+		return report.done()
+	case !started:
+		report.check("server", false, "Starting")
+		return report.done()
+	}
+
+	switch {
+	case s.dryRun != nil:
+		report.check("destination", true, "Dry run, printing packets")
+		report.check("queue", true, "Dry run, nothing queued")
+	case logger == nil:
+		report.check("destination", false, "Not connected")
+	default:
+		since := logger.DisconnectedSince()
+		switch {
+		case since.IsZero():
+			report.check("destination", true, "Connected")
+		case now.Sub(since) > config.Health.maxDisconnected():
+			report.check("destination", false, "Disconnected for %s", now.Sub(since).Round(time.Second))
+		default:
+			report.check("destination", true, "Reconnecting for %s", now.Sub(since).Round(time.Second))
+		}
+
+		queued, limit := logger.Pending(), config.Health.maxQueued()
+		if over := s.queueOver(queued >= limit, now); over.IsZero() {
+			report.check("queue", true, "%d packets queued, the limit is %d", queued, limit)
+		} else {
+			backedUp := now.Sub(over).Round(time.Second)
+			report.check("queue", backedUp < queueBackedUp, "%d packets queued, at or over the limit of %d for %s", queued, limit, backedUp)
+		}
+	}
+
+	files := s.registry.List()
+	s.progress.forget(files)
+
+	stalled := 0
+	for _, file := range files {
+		// compressed files are read in one go, and have no offset
+		offset, ok := s.offsets.Get(file)
+		if !ok || isCompressed(file) {
+			continue
+		}
+
+		fh := fileHealth{Path: file, Offset: offset}
+		if fi, err := os.Stat(file); err == nil {
+			fh.Size = fi.Size()
+		}
+
+		if d := s.progress.stalled(file, offset, fh.Size, now); d > 0 {
+			fh.Stalled = d.Round(time.Second).String()
+			if d > config.Health.maxStalled() {
+				stalled++
+			}
+		}
+		report.Files = append(report.Files, fh)
+	}
+
+	if stalled > 0 {
+		report.check("files", false, "%d of %d files stalled for over %s", stalled, len(report.Files), config.Health.maxStalled())
+	} else {
+		report.check("files", true, "%d files being forwarded", len(report.Files))
+	}
+
+	return report.done()
+}
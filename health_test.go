@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	var p progress
+	start := time.Now()
+
+	// a file is only stalled while it has lines waiting and its offset
+	// doesn't move
+	assert.Equal(time.Duration(0), p.stalled("a.log", 10, 20, start))
+	assert.Equal(time.Minute, p.stalled("a.log", 10, 20, start.Add(time.Minute)))
+	assert.Equal(time.Duration(0), p.stalled("a.log", 15, 20, start.Add(2*time.Minute)))
+	assert.Equal(time.Duration(0), p.stalled("a.log", 15, 15, start.Add(3*time.Minute)))
+	assert.Equal(time.Duration(0), p.stalled("a.log", 15, 15, start.Add(4*time.Minute)))
+
+	p.stalled("b.log", 0, 20, start)
+	p.forget([]string{"a.log"})
+	assert.Equal(time.Duration(0), p.stalled("b.log", 0, 20, start.Add(time.Minute)))
+}
+
+func TestQueueOver(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewServer(testConfig())
+	start := time.Now()
+
+	// the queue is backed up from the first probe finding it over the
+	// limit until one finds it under
+	assert.True(s.queueOver(false, start).IsZero())
+	assert.Equal(start, s.queueOver(true, start))
+	assert.Equal(start, s.queueOver(true, start.Add(time.Minute)))
+	assert.True(s.queueOver(false, start.Add(2*time.Minute)).IsZero())
+	assert.Equal(start.Add(3*time.Minute), s.queueOver(true, start.Add(3*time.Minute)))
+}
+
+func TestHealth(t *testing.T) {
+	assert := assert.New(t)
+
+	file := createFile(t, tmpdir+"/health.txt")
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	config := testConfig()
+	config.Files = []LogFile{{Path: file.Name()}}
+	config.Health.MaxStalled = time.Millisecond
+
+	s := NewServer(config)
+	handler := s.healthHandler()
+
+	get := func(path string) (int, healthReport) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		var report healthReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+
+	code, report := get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal("Starting", report.Checks["server"].Message)
+
+	go s.Start()
+	time.Sleep(1 * time.Second)
+
+	code, report = get("/healthz")
+	assert.Equal(http.StatusOK, code)
+	assert.True(report.Checks["tailing"].OK)
+
+	code, report = get("/readyz")
+	assert.Equal(http.StatusOK, code, "%+v", report)
+	assert.True(report.Checks["destination"].OK)
+	assert.True(report.Checks["queue"].OK)
+	if assert.Len(report.Files, 1) {
+		assert.Equal(filepath.Clean(file.Name()), report.Files[0].Path)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	assert.Contains(w.Body.String(), "suppressed_lines")
+	// the command line can hold the token
+	assert.NotContains(w.Body.String(), `"cmdline"`)
+	var vars map[string]interface{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &vars))
+
+	s.Close()
+
+	code, report = get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal("Shutting down", report.Checks["server"].Message)
+}
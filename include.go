@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/papertrail/remote_syslog2/utils"
+	"github.com/spf13/viper"
+)
+
+// defaultInclude is the include glob used when none is configured: *.yml
+// in the .d directory beside the config file, like /etc/log_files.d/*.yml
+func defaultInclude(configFile string) string {
+	return strings.TrimSuffix(configFile, filepath.Ext(configFile)) + ".d/*.yml"
+}
+
+// The settings an included file can have
+type includedConfig struct {
+	ExcludePatterns []*regexp.Regexp `mapstructure:"exclude_patterns"`
+	Fields          map[string]string
+}
+
+// include merges the files matching the include globs into c, in the
+// order of the globs and then of the files' names. Relative globs are
+// relative to the config file. Each file's files and exclude_patterns are
+// added to c's. A file path or field that is already configured is
+// reported and left as it was, so one included file can't change what
+// another configured.
+func (c *Config) include(configFile string, globs []string) ConfigError {
+	var problems ConfigError
+
+	// where each file path and field was configured, for reporting
+	// conflicts
+	paths := make(map[string]string)
+	for _, lf := range c.Files {
+		paths[lf.Path] = configFile
+	}
+	fields := make(map[string]string)
+	for name := range c.Fields {
+		fields[name] = configFile
+	}
+
+	for _, glob := range globs {
+		if !filepath.IsAbs(glob) {
+			glob = filepath.Join(filepath.Dir(configFile), glob)
+		}
+
+		matches, err := filepath.Glob(utils.ResolvePath(glob))
+		if err != nil {
+			problems = append(problems, ConfigProblem{Key: "include", Message: err.Error()})
+			continue
+		}
+		sort.Strings(matches)
+
+		for _, file := range matches {
+			log.Debugf("Including %s", file)
+
+			inc, files, fileProblems := readIncluded(file)
+			problems = append(problems, fileProblems...)
+
+			for _, lf := range files {
+				if where, ok := paths[lf.Path]; ok {
+					problems = append(problems, ConfigProblem{
+						File:    file,
+						Key:     "files",
+						Message: fmt.Sprintf("%s is already configured in %s, ignoring it", lf.Path, where),
+						Warning: true,
+					})
+					continue
+				}
+
+				paths[lf.Path] = file
+				c.Files = append(c.Files, lf)
+			}
+
+			c.ExcludePatterns = append(c.ExcludePatterns, inc.ExcludePatterns...)
+
+			// sorted, so conflicts are reported in the same order each time
+			names := make([]string, 0, len(inc.Fields))
+			for name := range inc.Fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				value := inc.Fields[name]
+
+				if where, ok := fields[name]; ok {
+					if c.Fields[name] != value {
+						problems = append(problems, ConfigProblem{
+							File:    file,
+							Key:     "fields." + name,
+							Message: fmt.Sprintf("Already set to %q in %s, ignoring %q", c.Fields[name], where, value),
+							Warning: true,
+						})
+					}
+					continue
+				}
+
+				if c.Fields == nil {
+					c.Fields = make(map[string]string)
+				}
+				fields[name] = file
+				c.Fields[name] = value
+			}
+		}
+	}
+
+	return problems
+}
+
+// readIncluded reads an included file, returning its settings and every
+// problem found in it
+func readIncluded(file string) (includedConfig, []LogFile, ConfigError) {
+	var (
+		inc      includedConfig
+		files    []LogFile
+		problems ConfigError
+	)
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return inc, nil, ConfigError{{File: file, Message: err.Error()}}
+	}
+
+	settings := v.AllSettings()
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch key {
+		case "files", "exclude_patterns", "fields":
+		default:
+			problems = append(problems, ConfigProblem{
+				Key:     key,
+				Message: "Only files, exclude_patterns and fields can be set in an included file",
+				Warning: true,
+			})
+			delete(settings, key)
+		}
+	}
+
+	if f, ok := settings["files"]; ok {
+		delete(settings, "files")
+
+		var err error
+		files, err = decodeLogFiles(f)
+		problems.add("files", err)
+	}
+
+	problems = append(problems, decodeSettings("", settings, &inc)...)
+
+	for i := range problems {
+		problems[i].File = file
+	}
+
+	return inc, files, problems
+}
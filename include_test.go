@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInclude(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := filepath.Join(tmpdir, "log_files.d")
+	os.Mkdir(dir, 0755)
+	defer os.RemoveAll(dir)
+
+	fragments := map[string]string{
+		// read in name order, whatever order they were written in
+		"b-web.yml": `
+files:
+  - path: /var/log/web.log
+    tag: web
+  - path: /var/log/app.log
+    tag: web-app
+exclude_patterns:
+  - healthcheck
+fields:
+  team: web
+`,
+		"a-app.yml": `
+files:
+  - path: /var/log/worker.log
+    tag: worker
+fields:
+  team: app
+  service: billing
+destination:
+  host: elsewhere.example.com
+`,
+		"c-bad.yml": `
+exclude_patterns:
+  - "(unclosed"
+`,
+	}
+	for name, content := range fragments {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configFile := filepath.Join(tmpdir, "log_files.yml")
+	assert.Equal(dir+"/*.yml", defaultInclude(configFile))
+
+	c := &Config{
+		Files:           []LogFile{{Path: "/var/log/app.log", Tag: "app"}},
+		ExcludePatterns: []*regexp.Regexp{regexp.MustCompile("debug")},
+		Fields:          map[string]string{"environment": "production"},
+	}
+
+	// relative globs are relative to the config file
+	problems := c.include(configFile, []string{"log_files.d/*.yml"})
+
+	assert.Equal([]LogFile{
+		{Path: "/var/log/app.log", Tag: "app"},
+		{Path: "/var/log/worker.log", Tag: "worker"},
+		{Path: "/var/log/web.log", Tag: "web"},
+	}, c.Files)
+	assert.Equal([]*regexp.Regexp{regexp.MustCompile("debug"), regexp.MustCompile("healthcheck")}, c.ExcludePatterns)
+	assert.Equal(map[string]string{"environment": "production", "team": "app", "service": "billing"}, c.Fields)
+
+	if assert.Len(problems, 4) {
+		assert.Equal(ConfigProblem{
+			File:    filepath.Join(dir, "a-app.yml"),
+			Key:     "destination",
+			Message: "Only files, exclude_patterns and fields can be set in an included file",
+			Warning: true,
+		}, problems[0])
+
+		assert.Equal(filepath.Join(dir, "b-web.yml"), problems[1].File)
+		assert.Equal("files", problems[1].Key)
+		assert.Contains(problems[1].Message, "/var/log/app.log is already configured in "+configFile)
+		assert.True(problems[1].Warning)
+
+		assert.Equal(filepath.Join(dir, "b-web.yml"), problems[2].File)
+		assert.Equal("fields.team", problems[2].Key)
+		assert.True(problems[2].Warning)
+
+		assert.Equal(filepath.Join(dir, "c-bad.yml"), problems[3].File)
+		assert.Equal("exclude_patterns", problems[3].Key)
+		assert.False(problems[3].Warning)
+	}
+}
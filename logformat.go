@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/howbazaar/loggo"
+)
+
+// Formats for our own log
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+func validLogFormat(format string) error {
+	switch format {
+	case "", LogFormatText, LogFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("Invalid log format %q, must be %s or %s", format, LogFormatText, LogFormatJSON)
+}
+
+// Keys of the fields that key events carry
+const (
+	fieldEvent       = "event"
+	fieldPath        = "path"
+	fieldDestination = "destination"
+	fieldError       = "error"
+	fieldOffset      = "offset"
+	fieldCount       = "count"
+	fieldAbandoned   = "abandoned"
+)
+
+// Key events, so they can be picked out of the JSON log
+const (
+	eventFileStarted      = "file_started"
+	eventFileStopped      = "file_stopped"
+	eventConnecting       = "connecting"
+	eventConnectFailed    = "connect_failed"
+	eventDestinationError = "destination_error"
+	eventDisconnected     = "disconnected"
+	eventReconnected      = "reconnected"
+	eventLinesSuppressed  = "lines_suppressed"
+	eventPacketsFlushed   = "packets_flushed"
+)
+
+// logFields are the machine-readable fields of a key event
+type logFields map[string]interface{}
+
+// fieldSep separates a message from its fields as they pass through
+// loggo, which only carries a string
+const fieldSep = "\x1f"
+
+// logEvent logs a key event. The JSON log format gives the event and each
+// field their own keys; the text format only shows the message.
+func logEvent(level loggo.Level, event string, fields logFields, format string, args ...interface{}) {
+	all := logFields{fieldEvent: event}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		all[k] = v
+	}
+
+	encoded, err := json.Marshal(all)
+	if err != nil {
+		encoded = []byte("{}")
+	}
+
+	// called directly, so loggo reports our caller's file and line
+	log.Logf(level, "%s", fmt.Sprintf(format, args...)+fieldSep+string(encoded))
+}
+
+// splitFields separates a message logged by logEvent from its fields
+func splitFields(message string) (string, logFields) {
+	i := strings.Index(message, fieldSep)
+	if i < 0 {
+		return message, nil
+	}
+
+	// numbers stay as written, rather than become floats that print
+	// large offsets like 1.2345678e+07
+	var fields logFields
+	d := json.NewDecoder(strings.NewReader(message[i+len(fieldSep):]))
+	d.UseNumber()
+	d.Decode(&fields)
+	return message[:i], fields
+}
+
+// A logWriter writes our own log to w, as text like loggo's default
+// writer or as a JSON object per line
+type logWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+}
+
+func (w *logWriter) Write(level loggo.Level, module, filename string, line int, timestamp time.Time, message string) {
+	message, fields := splitFields(message)
+
+	var out string
+	if w.json {
+		record := make(map[string]interface{}, len(fields)+6)
+		for k, v := range fields {
+			record[k] = v
+		}
+		record["time"] = timestamp.UTC().Format(time.RFC3339Nano)
+		record["level"] = level.String()
+		record["module"] = module
+		record["caller"] = fmt.Sprintf("%s:%d", filepath.Base(filename), line)
+		record["message"] = message
+
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		out = string(encoded)
+	} else {
+		out = (&loggo.DefaultFormatter{}).Format(level, module, filename, line, timestamp, message)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintln(w.w, out)
+}
+
+// setLogFormat makes loggo write our own log to stderr in the format,
+// masking secrets
+func setLogFormat(format string) {
+	loggo.ReplaceDefaultWriter(maskingWriter{&logWriter{w: os.Stderr, json: format == LogFormatJSON}})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/howbazaar/loggo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	w := &loggo.TestWriter{}
+	loggo.RegisterWriter("test", w, loggo.TRACE)
+	defer loggo.RemoveWriter("test")
+
+	logEvent(loggo.ERROR, eventDestinationError, logFields{fieldDestination: "example.com:514", fieldError: errors.New("refused")}, "Syslog error: %s", "refused")
+
+	if assert.Len(w.Log, 1) {
+		assert.Equal("logformat_test.go", w.Log[0].Filename)
+
+		message, fields := splitFields(w.Log[0].Message)
+		assert.Equal("Syslog error: refused", message)
+		assert.Equal(logFields{
+			fieldEvent:       eventDestinationError,
+			fieldDestination: "example.com:514",
+			fieldError:       "refused",
+		}, fields)
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	message := "Forwarding file: /var/log/app.log" + fieldSep + `{"event":"file_started","path":"/var/log/app.log"}`
+
+	var buf bytes.Buffer
+	w := &logWriter{w: &buf}
+	w.Write(loggo.INFO, "", "/src/remote_syslog.go", 10, at, message)
+	w.Write(loggo.WARNING, "", "/src/config.go", 20, at, "Plain message")
+
+	// text only shows the message
+	assert.Equal("2024-05-01 12:00:00 INFO  remote_syslog.go:10 Forwarding file: /var/log/app.log\n"+
+		"2024-05-01 12:00:00 WARNING  config.go:20 Plain message\n", buf.String())
+
+	buf.Reset()
+	w = &logWriter{w: &buf, json: true}
+	w.Write(loggo.INFO, "", "/src/remote_syslog.go", 10, at, message)
+	w.Write(loggo.WARNING, "", "/src/config.go", 20, at, "Plain message")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(lines, 2) {
+		var record map[string]interface{}
+		assert.NoError(json.Unmarshal([]byte(lines[0]), &record))
+		assert.Equal(map[string]interface{}{
+			"time":    "2024-05-01T12:00:00Z",
+			"level":   "INFO",
+			"module":  "",
+			"caller":  "remote_syslog.go:10",
+			"message": "Forwarding file: /var/log/app.log",
+			"event":   "file_started",
+			"path":    "/var/log/app.log",
+		}, record)
+
+		record = nil
+		assert.NoError(json.Unmarshal([]byte(lines[1]), &record))
+		assert.Equal("Plain message", record["message"])
+		assert.Nil(record["event"])
+	}
+}
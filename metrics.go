@@ -0,0 +1,15 @@
+package main
+
+import (
+	"expvar"
+)
+
+// Counters published through expvar, keyed by file path unless noted
+var (
+	suppressedLines = expvar.NewMap("suppressed_lines")
+	repeatedLines   = expvar.NewMap("repeated_lines")
+	truncations     = expvar.NewMap("truncations")
+
+	// keyed by redact rule name
+	redactions = expvar.NewMap("redactions")
+)
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// how many rotated or compressed files' contents are remembered
+const maxSeen = 1000
+
+// OffsetStore records how far into each file the tailers have read lines,
+// and which of those lines are still being sent, so a file is only saved
+// as far as its lines have been written. It also remembers the contents of files that have been rotated
+// away or read compressed, by fingerprint, so they aren't sent twice when
+// found under another name. It is safe for concurrent use.
+type OffsetStore struct {
+	mu           sync.RWMutex
+	offsets      map[string]int64
+	fingerprints map[string]fingerprint
+	seen         []seenContent
+
+	// unsent holds, for each file, the lines read but not yet written,
+	// oldest first
+	unsent map[string][]unsentLine
+}
+
+// unsentLine is a line being sent, which starts at offset. result
+// receives whether it was written.
+type unsentLine struct {
+	offset int64
+	result <-chan error
+}
+
+// seenContent records how much of some file contents has been sent
+type seenContent struct {
+	Fingerprint fingerprint `json:"fingerprint"`
+	Offset      int64       `json:"offset"`
+
+	// Complete is set once the contents were sent to the end of a
+	// compressed file, which can't grow any more
+	Complete bool `json:"complete,omitempty"`
+}
+
+// the state file format
+type offsetState struct {
+	Files map[string]fileState `json:"files"`
+	Seen  []seenContent        `json:"seen"`
+}
+
+type fileState struct {
+	Offset      int64       `json:"offset"`
+	Fingerprint fingerprint `json:"fingerprint"`
+}
+
+func NewOffsetStore() *OffsetStore {
+	return &OffsetStore{
+		offsets:      make(map[string]int64),
+		fingerprints: make(map[string]fingerprint),
+		unsent:       make(map[string][]unsentLine),
+	}
+}
+
+// Get returns how far into a file lines have been read and whether an
+// offset is recorded for it
+func (o *OffsetStore) Get(file string) (int64, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	offset, ok := o.offsets[file]
+	return offset, ok
+}
+
+// Set records how far into a file lines have been read. Once a file goes
+// back, having been truncated, the lines still being sent from before
+// don't hold it back.
+func (o *OffsetStore) Set(file string, offset int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if offset < o.offsets[file] {
+		delete(o.unsent, file)
+	}
+	o.offsets[file] = offset
+}
+
+// Sending records that the line of a file starting at offset is being
+// sent, with result receiving whether it was written. Until it has been,
+// the file is saved at offset at most, so the line is sent again after a
+// restart.
+func (o *OffsetStore) Sending(file string, offset int64, result <-chan error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.unsent[file] = append(o.written(file), unsentLine{offset, result})
+}
+
+// written forgets the lines at the front of a file's unsent lines that
+// have been written, returning those left. A line that failed is never
+// forgotten, so nothing after it is saved. It must be called with mu held
+// for writing.
+func (o *OffsetStore) written(file string) []unsentLine {
+	lines := o.unsent[file]
+	for len(lines) > 0 {
+		select {
+		case err := <-lines[0].result:
+			if err == nil {
+				lines = lines[1:]
+				continue
+			}
+			lines[0].result = nil
+		default:
+		}
+		break
+	}
+
+	if len(lines) == 0 {
+		delete(o.unsent, file)
+		return nil
+	}
+	o.unsent[file] = lines
+	return lines
+}
+
+// sent returns how far into a file lines have been written: up to the
+// first line still being sent, if any. It must be called with mu held for
+// writing.
+func (o *OffsetStore) sent(file string) int64 {
+	if lines := o.written(file); len(lines) > 0 {
+		return lines[0].offset
+	}
+	return o.offsets[file]
+}
+
+// All returns how far into each file lines have been written
+func (o *OffsetStore) All() map[string]int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	all := make(map[string]int64, len(o.offsets))
+	for file := range o.offsets {
+		all[file] = o.sent(file)
+	}
+	return all
+}
+
+// Fingerprint returns the recorded fingerprint of a file's contents
+func (o *OffsetStore) Fingerprint(file string) (fingerprint, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	fp, ok := o.fingerprints[file]
+	return fp, ok
+}
+
+// SetFingerprint records the fingerprint of a file's contents
+func (o *OffsetStore) SetFingerprint(file string, fp fingerprint) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.fingerprints[file] = fp
+}
+
+// Rotated forgets a file whose contents have been moved away, remembering
+// how far into them we got so they can be recognized wherever they went
+func (o *OffsetStore) Rotated(file string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if fp := o.fingerprints[file]; !fp.empty() {
+		o.markSeen(seenContent{Fingerprint: fp, Offset: o.offsets[file]})
+	}
+
+	delete(o.offsets, file)
+	delete(o.fingerprints, file)
+	delete(o.unsent, file)
+}
+
+// MarkSeen records how much of the contents with a fingerprint were sent
+func (o *OffsetStore) MarkSeen(fp fingerprint, offset int64, complete bool) {
+	if fp.empty() {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.markSeen(seenContent{Fingerprint: fp, Offset: offset, Complete: complete})
+}
+
+func (o *OffsetStore) markSeen(c seenContent) {
+	for i, s := range o.seen {
+		if s.Fingerprint == c.Fingerprint {
+			if s.Offset > c.Offset {
+				c.Offset = s.Offset
+			}
+			c.Complete = c.Complete || s.Complete
+
+			o.seen = append(o.seen[:i], o.seen[i+1:]...)
+			break
+		}
+	}
+
+	o.seen = append(o.seen, c)
+	if len(o.seen) > maxSeen {
+		o.seen = o.seen[len(o.seen)-maxSeen:]
+	}
+}
+
+// Seen returns what was recorded about the contents of a file starting
+// with head. The longest matching fingerprint wins, since a short one
+// only says the file starts the same way.
+func (o *OffsetStore) Seen(head []byte) (seenContent, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	var (
+		best  seenContent
+		found bool
+	)
+	for _, s := range o.seen {
+		if s.Fingerprint.matches(head) && (!found || s.Fingerprint.Size > best.Fingerprint.Size) {
+			best, found = s, true
+		}
+	}
+	return best, found
+}
+
+// Load adds the offsets saved in a state file. A missing file isn't an
+// error, it just means nothing was saved yet.
+func (o *OffsetStore) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state offsetState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	// state files used to hold just the offsets
+	if state.Files == nil && state.Seen == nil {
+		var offsets map[string]int64
+		if err := json.Unmarshal(data, &offsets); err != nil {
+			return err
+		}
+
+		state.Files = make(map[string]fileState, len(offsets))
+		for file, offset := range offsets {
+			state.Files[file] = fileState{Offset: offset}
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for file, f := range state.Files {
+		o.offsets[file] = f.Offset
+		if !f.Fingerprint.empty() {
+			o.fingerprints[file] = f.Fingerprint
+		}
+	}
+	for _, s := range state.Seen {
+		o.markSeen(s)
+	}
+	return nil
+}
+
+// Save writes how far into each file lines have been written to a state
+// file, replacing it atomically so a crash can't leave it half written
+func (o *OffsetStore) Save(path string) error {
+	o.mu.Lock()
+	state := offsetState{
+		Files: make(map[string]fileState, len(o.offsets)),
+		Seen:  o.seen,
+	}
+	for file := range o.offsets {
+		state.Files[file] = fileState{Offset: o.sent(file), Fingerprint: o.fingerprints[file]}
+	}
+	data, err := json.Marshal(state)
+	o.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
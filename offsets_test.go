@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/papertrail/remote_syslog2/syslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffsetStoreRotated(t *testing.T) {
+	assert := assert.New(t)
+
+	o := NewOffsetStore()
+	head := []byte("first line\nsecond line\n")
+
+	o.Set("app.log", 11)
+	o.SetFingerprint("app.log", newFingerprint(head))
+	o.Rotated("app.log")
+
+	_, ok := o.Get("app.log")
+	assert.False(ok, "Expected the rotated file to be forgotten")
+
+	seen, ok := o.Seen(head)
+	assert.True(ok, "Expected the rotated contents to be recognized")
+	assert.Equal(int64(11), seen.Offset)
+	assert.False(seen.Complete)
+
+	_, ok = o.Seen([]byte("something else\n"))
+	assert.False(ok)
+
+	// offsets only move forward, and a complete read stays complete
+	o.MarkSeen(newFingerprint(head), 23, true)
+	o.MarkSeen(newFingerprint(head), 5, false)
+	seen, _ = o.Seen(head)
+	assert.Equal(int64(23), seen.Offset)
+	assert.True(seen.Complete)
+
+	// the longest matching fingerprint wins
+	o.MarkSeen(newFingerprint(head[:5]), 2, false)
+	seen, _ = o.Seen(head)
+	assert.Equal(int64(23), seen.Offset)
+}
+
+func TestOffsetStoreSending(t *testing.T) {
+	assert := assert.New(t)
+
+	o := NewOffsetStore()
+	first, second, third := make(chan error, 1), make(chan error, 1), make(chan error, 1)
+
+	o.Sending("app.log", 0, first)
+	o.Set("app.log", 10)
+	o.Sending("app.log", 10, second)
+	o.Set("app.log", 20)
+	o.Sending("app.log", 20, third)
+	o.Set("app.log", 30)
+
+	// a file is only as far as its oldest line not yet written
+	assert.Equal(map[string]int64{"app.log": 0}, o.All())
+	offset, _ := o.Get("app.log")
+	assert.Equal(int64(30), offset)
+
+	second <- nil
+	assert.Equal(map[string]int64{"app.log": 0}, o.All())
+
+	first <- nil
+	assert.Equal(map[string]int64{"app.log": 20}, o.All())
+
+	// a line that is never written holds the file back for good
+	third <- syslog.ErrClosed
+	assert.Equal(map[string]int64{"app.log": 20}, o.All())
+	assert.Equal(map[string]int64{"app.log": 20}, o.All())
+
+	// unless the file is truncated
+	o.Set("app.log", 0)
+	assert.Equal(map[string]int64{"app.log": 0}, o.All())
+	o.Set("app.log", 5)
+	assert.Equal(map[string]int64{"app.log": 5}, o.All())
+}
+
+func TestOffsetStoreSaveLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "offsets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	head := []byte("rotated away\n")
+
+	o := NewOffsetStore()
+	o.Set("a.log", 42)
+	o.SetFingerprint("a.log", newFingerprint([]byte("a\n")))
+	o.MarkSeen(newFingerprint(head), 13, true)
+	assert.NoError(o.Save(path))
+
+	loaded := NewOffsetStore()
+	assert.NoError(loaded.Load(path))
+	assert.Equal(map[string]int64{"a.log": 42}, loaded.All())
+
+	fp, ok := loaded.Fingerprint("a.log")
+	assert.True(ok)
+	assert.Equal(newFingerprint([]byte("a\n")), fp)
+
+	seen, ok := loaded.Seen(head)
+	assert.True(ok)
+	assert.Equal(seenContent{Fingerprint: newFingerprint(head), Offset: 13, Complete: true}, seen)
+
+	// state files used to hold just the offsets
+	assert.NoError(ioutil.WriteFile(path, []byte(`{"b.log": 7}`), 0644))
+	old := NewOffsetStore()
+	assert.NoError(old.Load(path))
+	assert.Equal(map[string]int64{"b.log": 7}, old.All())
+
+	// a missing state file isn't an error
+	assert.NoError(NewOffsetStore().Load(filepath.Join(dir, "missing.json")))
+}
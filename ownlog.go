@@ -0,0 +1,193 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/howbazaar/loggo"
+	"github.com/papertrail/remote_syslog2/syslog"
+)
+
+// The tag our own log records are sent with if not configured
+const defaultOwnLogTag = "remote_syslog"
+
+// the name of the loggo writer sending our own log records
+const ownLogWriterName = "destination"
+
+// At most this many of our own log records are sent per second, in bursts
+// of up to ownLogBurst, so a flood of errors can't crowd out the files
+const (
+	ownLogRate  = 10
+	ownLogBurst = 50
+)
+
+// our own log records that weren't sent, keyed by why
+var ownLogDropped = expvar.NewMap("own_log_dropped")
+
+// OwnLog configures sending our own log records to the destination
+type OwnLog struct {
+	// Level is the lowest level sent, like WARNING, and can't be below
+	// INFO. Nothing is sent when it's empty.
+	Level string
+	Tag   string
+}
+
+// validOwnLogLevel rejects levels below INFO, since DEBUG and TRACE records
+// quote the lines being forwarded before they are redacted
+func validOwnLogLevel(level string) error {
+	if level == "" {
+		return nil
+	}
+	l, ok := loggo.ParseLevel(level)
+	if !ok {
+		return fmt.Errorf("Invalid level %q, try WARNING or ERROR", level)
+	}
+	if l < loggo.INFO {
+		return fmt.Errorf("Level %s would send lines before they are redacted, use INFO or above", l)
+	}
+	return nil
+}
+
+// events about the destination, which are never sent to it
+var destinationEvents = map[string]bool{
+	eventConnectFailed:    true,
+	eventDestinationError: true,
+	eventDisconnected:     true,
+}
+
+// An ownLogWriter is a loggo writer that sends our own log records through
+// the logger. To stop a failing destination feeding itself, records aren't
+// sent while the connection is down, records about the destination are
+// never sent, and records are dropped rather than wait for a full queue or
+// when there are too many.
+type ownLogWriter struct {
+	logger  *syslog.Logger
+	limiter *rateLimiter
+
+	// the settings, which Reload can change while records are written
+	settings atomic.Value
+}
+
+type ownLogSettings struct {
+	level    loggo.Level
+	tag      string
+	hostname string
+	token    string
+	facility syslog.Priority
+}
+
+func newOwnLogWriter(logger *syslog.Logger, c *Config) *ownLogWriter {
+	w := &ownLogWriter{
+		logger:  logger,
+		limiter: newRateLimiter(RateLimit{LinesPerSecond: ownLogRate, LineBurst: ownLogBurst}),
+	}
+	w.configure(c)
+	return w
+}
+
+// configure takes the settings from c. It doesn't log, so can be called
+// while holding the server's lock.
+func (w *ownLogWriter) configure(c *Config) {
+	settings := ownLogSettings{
+		tag:      c.OwnLog.Tag,
+		hostname: c.Hostname,
+		token:    c.Destination.Token,
+		facility: c.Facility,
+	}
+
+	if c.OwnLog.Level != "" {
+		settings.level, _ = loggo.ParseLevel(c.OwnLog.Level)
+	}
+	if settings.tag == "" {
+		settings.tag = defaultOwnLogTag
+	}
+
+	w.settings.Store(settings)
+}
+
+func (w *ownLogWriter) Write(level loggo.Level, module, filename string, line int, timestamp time.Time, message string) {
+	settings := w.settings.Load().(ownLogSettings)
+	if settings.level == loggo.UNSPECIFIED || level < settings.level {
+		return
+	}
+
+	message, fields := splitFields(message)
+
+	event, _ := fields[fieldEvent].(string)
+	switch {
+	case destinationEvents[event]:
+		return
+	case !w.logger.DisconnectedSince().IsZero():
+		ownLogDropped.Add("disconnected", 1)
+		return
+	}
+
+	if _, ok := w.limiter.reserve(0); !ok {
+		ownLogDropped.Add("rate_limit", 1)
+		return
+	}
+
+	packet := syslog.Packet{
+		Severity: ownLogSeverity(level),
+		Facility: settings.facility,
+		Time:     timestamp,
+		Hostname: settings.hostname,
+		Tag:      settings.tag,
+		Token:    settings.token,
+		Message:  message,
+		Fields:   packetFields(fields),
+	}
+
+	if !w.logger.TryWrite(packet) {
+		ownLogDropped.Add("queue_full", 1)
+	}
+}
+
+// ownLogSeverity maps a log level to the syslog severity it's sent with
+func ownLogSeverity(level loggo.Level) syslog.Priority {
+	switch level {
+	case loggo.CRITICAL:
+		return syslog.SevCrit
+	case loggo.ERROR:
+		return syslog.SevErr
+	case loggo.WARNING:
+		return syslog.SevWarning
+	case loggo.INFO:
+		return syslog.SevInfo
+	default:
+		return syslog.SevDebug
+	}
+}
+
+// packetFields turns an event's log fields into packet fields
+func packetFields(fields logFields) map[string]string {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	packet := make(map[string]string, len(fields))
+	for name, value := range fields {
+		name = strings.ToLower(name)
+		if syslog.ValidFieldName(name) {
+			packet[name] = fmt.Sprint(value)
+		}
+	}
+	return packet
+}
+
+// forwardOwnLog starts sending our own log records to the destination,
+// masking secrets like the local log does
+func (s *Server) forwardOwnLog() {
+	s.ownLog = newOwnLogWriter(s.logger, s.config)
+	loggo.RegisterWriter(ownLogWriterName, maskingWriter{s.ownLog}, loggo.TRACE)
+}
+
+// stopOwnLog stops sending our own log records, before the logger closes
+func (s *Server) stopOwnLog() {
+	if s.ownLog != nil {
+		loggo.RemoveWriter(ownLogWriterName)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/howbazaar/loggo"
+	"github.com/papertrail/remote_syslog2/syslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardOwnLog(t *testing.T) {
+	assert := assert.New(t)
+
+	config := testConfig()
+	config.Files = nil
+	config.OwnLog = OwnLog{Level: "WARNING", Tag: "agent"}
+
+	s := NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	time.Sleep(1 * time.Second)
+
+	// only records at or above the level are sent, and never those about
+	// the destination
+	log.Infof("own log info")
+	logEvent(loggo.ERROR, eventDestinationError, logFields{fieldError: "refused"}, "own log destination error")
+	log.Warningf("own log warning")
+	logEvent(loggo.ERROR, eventFileStarted, logFields{fieldPath: "/var/log/app.log", fieldOffset: int64(12345678901)}, "own log event")
+
+	packet, received := receivePrefixed("own log ")
+	if assert.True(received) {
+		assert.Equal("own log warning", packet.Message)
+		assert.Equal("agent", packet.Tag)
+		assert.Equal(syslog.SevWarning, packet.Severity)
+	}
+
+	packet, received = receivePrefixed("own log ")
+	if assert.True(received) {
+		assert.Equal("own log event", packet.Message)
+		assert.Equal(syslog.SevErr, packet.Severity)
+		// numbers are sent as written, however large
+		assert.Equal(map[string]string{"event": eventFileStarted, "path": "/var/log/app.log", "offset": "12345678901"}, packet.Fields)
+	}
+}
+
+func TestValidOwnLogLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, level := range []string{"", "INFO", "WARNING", "error"} {
+		assert.NoError(validOwnLogLevel(level), level)
+	}
+
+	// records below INFO quote lines before they are redacted
+	for _, level := range []string{"DEBUG", "TRACE", "bogus"} {
+		assert.Error(validOwnLogLevel(level), level)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/howbazaar/loggo"
+)
+
+// A pipeline takes the lines of one file through filtering, redaction,
+// deduplication and rate limiting, and writes the survivors to the logger.
+// It is not safe for concurrent use.
+type pipeline struct {
+	s       *Server
+	file    string
+	lf      LogFile
+	redact  []*RedactRule
+	limiter *rateLimiter
+	dedup   *deduper
+
+	// lines dropped by the rate limits since the last summary
+	suppressed int
+
+	// totals, for reporting how a file was handled
+	forwarded, filtered, repeated, limited int
+}
+
+func (s *Server) newPipeline(file string, lf LogFile) *pipeline {
+	if lf.Tag == "" {
+		lf.Tag = path.Base(file)
+	}
+
+	lf.Fields = mergeFields(s.config.Fields, lf.Fields)
+
+	// the global redact rules run before the file's own
+	redact := make([]*RedactRule, 0, len(s.config.Redact)+len(lf.Redact))
+	redact = append(redact, s.config.Redact...)
+	redact = append(redact, lf.Redact...)
+
+	return &pipeline{
+		s:       s,
+		file:    file,
+		lf:      lf,
+		redact:  redact,
+		limiter: newRateLimiter(lf.RateLimit),
+		dedup:   newDeduper(lf.Dedup),
+	}
+}
+
+// rateLimited reports whether the file is subject to a rate limit, and so
+// needs reportSuppressed calling periodically
+func (p *pipeline) rateLimited() bool {
+	return p.limiter != nil || p.s.limiter != nil
+}
+
+// send handles the line of the file starting at offset, which is recorded
+// as being sent until it has been written, so the file isn't saved past
+// it before then
+func (p *pipeline) send(l string, offset int64) {
+	if result := p.line(l, time.Time{}); result != nil {
+		p.s.offsets.Sending(p.file, offset, result)
+	}
+}
+
+// line handles one line from the file. at is when the line was logged, or
+// zero to use the current time. It returns the channel receiving whether
+// the line was written, or nil if it isn't being sent.
+func (p *pipeline) line(l string, at time.Time) <-chan error {
+	ok, exp := p.s.filterLine(l, p.lf)
+	v := lineVerdict(ok, exp)
+	if !ok {
+		p.filtered++
+		p.drop(l, v)
+		log.Tracef("Not Forwarding line: %s", l)
+		return nil
+	}
+
+	l = redactLine(l, p.redact)
+
+	if p.dedup != nil {
+		forward, summary := p.dedup.check(l, time.Now())
+		p.reportRepeats(summary)
+
+		if !forward {
+			p.repeated++
+			repeatedLines.Add(p.file, 1)
+			p.drop(l, verdict{"repeated", "dedup"})
+			log.Tracef("Repeated line: %s", l)
+			return nil
+		}
+	}
+
+	if !admitLine(len(l), p.s.stopChan, p.limiter, p.s.limiter) {
+		p.suppressed++
+		p.limited++
+		suppressedLines.Add(p.file, 1)
+		p.drop(l, verdict{"rate limited", "rate_limit"})
+		log.Tracef("Rate limited line: %s", l)
+		return nil
+	}
+
+	result := p.write(l, at, v)
+	p.forwarded++
+	log.Tracef("Forwarding line: %s", l)
+	return result
+}
+
+// write sends a message, or prints it in a dry run along with why it is
+// being sent. It returns the channel receiving whether the message was
+// written, or nil in a dry run.
+func (p *pipeline) write(msg string, at time.Time, v verdict) <-chan error {
+	packet := p.s.packet(p.lf, msg)
+	if !at.IsZero() {
+		packet.Time = at
+	}
+
+	if p.s.dryRun != nil {
+		p.s.dryRun.packet(p.file, v, packet)
+		return nil
+	}
+
+	return p.s.logger.WriteResult(packet)
+}
+
+// drop prints a line that won't be sent in a dry run
+func (p *pipeline) drop(l string, v verdict) {
+	if p.s.dryRun != nil {
+		p.s.dryRun.line(p.file, v, l)
+	}
+}
+
+// reportSuppressed sends a summary of the lines dropped by the rate limits
+// since the last one
+func (p *pipeline) reportSuppressed() {
+	if p.suppressed == 0 {
+		return
+	}
+
+	msg := fmt.Sprintf("%d lines suppressed from %s", p.suppressed, p.file)
+	logEvent(loggo.INFO, eventLinesSuppressed, logFields{fieldPath: p.file, fieldCount: p.suppressed}, "Rate limit: %s", msg)
+	p.write(msg, time.Time{}, verdict{"summary", "rate_limit"})
+	p.suppressed = 0
+}
+
+func (p *pipeline) reportRepeats(summary string) {
+	if summary != "" {
+		p.write(summary, time.Time{}, verdict{"summary", "dedup"})
+	}
+}
+
+// flushRepeats reports repeats whose window has passed
+func (p *pipeline) flushRepeats(now time.Time) {
+	if p.dedup != nil {
+		p.reportRepeats(p.dedup.flush(now))
+	}
+}
+
+// end reports anything still pending once the file is done with
+func (p *pipeline) end() {
+	if p.dedup != nil {
+		p.reportRepeats(p.dedup.end())
+	}
+	p.reportSuppressed()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/utils"
+)
+
+// how long to wait for the files found on startup to be opened before
+// dropping privileges anyway
+const openTimeout = 10 * time.Second
+
+// dropsPrivileges reports whether a user or group to switch to is set
+func (c *Config) dropsPrivileges() bool {
+	return c.User != "" || c.Group != ""
+}
+
+// dropPrivileges switches to the configured user and group once the files
+// found on startup have been opened, so they can still be read. Files
+// found later have to be readable by the user, unless the capability to
+// read any file is kept.
+func (s *Server) dropPrivileges() error {
+	<-s.globbed
+
+	opened := make(chan struct{})
+	go func() {
+		s.opening.Wait()
+		close(opened)
+	}()
+
+	select {
+	case <-opened:
+	case <-time.After(openTimeout):
+		log.Warningf("Not every file was opened within %s, changing user anyway", openTimeout)
+	}
+
+	if err := utils.DropPrivileges(s.config.User, s.config.Group, s.config.KeepReadCapability); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.droppedPrivileges = true
+	s.mu.Unlock()
+
+	log.Infof("Running as uid %d, gid %d", os.Getuid(), os.Getgid())
+	s.checkWritable()
+	return nil
+}
+
+// checkWritable logs the files still written after privileges are dropped
+// that the user can't write: the state file, which is replaced by a temp
+// file made in its directory, and the debug log, which is made again in
+// its directory when it's rotated or reopened
+func (s *Server) checkWritable() {
+	if s.config.StateFile != "" && !s.config.DryRun {
+		if err := canCreate(s.config.StateFile); err != nil {
+			log.Errorf("Cannot save offsets to %s: %s. Give uid %d, gid %d write access to its directory", s.config.StateFile, err, os.Getuid(), os.Getgid())
+		}
+	}
+
+	s.mu.RLock()
+	debugLog := s.debugLog
+	s.mu.RUnlock()
+
+	if debugLog == nil {
+		return
+	}
+	if fi, err := os.Stat(debugLog.path); err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	if err := canCreate(debugLog.path); err != nil {
+		log.Warningf("Cannot rotate or reopen %s: %s. Give uid %d, gid %d write access to its directory", debugLog.path, err, os.Getuid(), os.Getgid())
+	}
+}
+
+// canCreate checks a file can be made in the directory of path
+func canCreate(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// trackOpening returns a func for a tailer started by the first pass over
+// the globs to call once it has opened its file, so privileges aren't
+// dropped before then
+func (s *Server) trackOpening() func() {
+	select {
+	case <-s.globbed:
+		return func() {}
+	default:
+	}
+
+	if !s.config.dropsPrivileges() {
+		return func() {}
+	}
+
+	s.opening.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(s.opening.Done)
+	}
+}
+
+// unreadable reports whether a file found since privileges were dropped
+// can't be read by the user, logging it the first time
+func (s *Server) unreadable(file string) bool {
+	s.mu.RLock()
+	dropped := s.droppedPrivileges
+	s.mu.RUnlock()
+
+	if !dropped {
+		return false
+	}
+
+	f, err := os.Open(file)
+	if err == nil {
+		f.Close()
+
+		s.mu.Lock()
+		delete(s.unreadableFiles, file)
+		s.mu.Unlock()
+		return false
+	}
+	if !os.IsPermission(err) {
+		return false
+	}
+
+	s.mu.Lock()
+	logged := s.unreadableFiles[file]
+	s.unreadableFiles[file] = true
+	s.mu.Unlock()
+
+	if !logged {
+		log.Errorf("%s", unreadableError(file))
+	}
+	return true
+}
+
+func unreadableError(file string) error {
+	return fmt.Errorf("Cannot forward %s: uid %d, gid %d can't read it. Give them read access, or set keep_read_capability", file, os.Getuid(), os.Getgid())
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// how often "N lines suppressed" summaries are sent if not configured
+const defaultRateLimitSummaryInterval = 60 * time.Second
+
+// RateLimit configures a token bucket for lines and one for bytes. A zero
+// rate disables that bucket. Bursts default to one second's worth.
+type RateLimit struct {
+	LinesPerSecond float64 `mapstructure:"lines_per_second"`
+	BytesPerSecond float64 `mapstructure:"bytes_per_second"`
+	LineBurst      int     `mapstructure:"line_burst"`
+	ByteBurst      int     `mapstructure:"byte_burst"`
+
+	// Delay holds lines back until the limit allows them instead of
+	// dropping them
+	Delay bool `mapstructure:"delay"`
+}
+
+func (r RateLimit) enabled() bool {
+	return r.LinesPerSecond > 0 || r.BytesPerSecond > 0
+}
+
+// tokenBucket refills at rate tokens per second up to burst tokens. It is
+// not safe for concurrent use on its own; rateLimiter does the locking.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	b := float64(burst)
+	if b <= 0 {
+		b = rate
+	}
+	if b < 1 {
+		b = 1
+	}
+
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// clamp makes sure a request can ever be satisfied, so a single line
+// larger than the byte burst isn't held back forever
+func (b *tokenBucket) clamp(n float64) float64 {
+	if n > b.burst {
+		return b.burst
+	}
+	return n
+}
+
+// wait returns how long until n tokens are available
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b == nil || b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// A rateLimiter applies a RateLimit to lines. It is safe for concurrent
+// use, so a single rateLimiter can be shared by every tailer.
+type rateLimiter struct {
+	mu    sync.Mutex
+	lines *tokenBucket
+	bytes *tokenBucket
+	delay bool
+}
+
+// newRateLimiter returns nil if r doesn't limit anything
+func newRateLimiter(r RateLimit) *rateLimiter {
+	if !r.enabled() {
+		return nil
+	}
+
+	return &rateLimiter{
+		lines: newTokenBucket(r.LinesPerSecond, r.LineBurst),
+		bytes: newTokenBucket(r.BytesPerSecond, r.ByteBurst),
+		delay: r.Delay,
+	}
+}
+
+// reserve takes a line of size bytes from the buckets. If the tokens aren't
+// available yet and the limiter delays, the tokens are borrowed and the
+// time to wait is returned. Otherwise ok is false and nothing is taken.
+func (r *rateLimiter) reserve(size int) (wait time.Duration, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var lines, bytes float64 = 1, float64(size)
+
+	for _, b := range []*tokenBucket{r.lines, r.bytes} {
+		if b != nil {
+			b.refill(now)
+		}
+	}
+	if r.bytes != nil {
+		bytes = r.bytes.clamp(bytes)
+	}
+
+	wait = r.lines.wait(lines)
+	if w := r.bytes.wait(bytes); w > wait {
+		wait = w
+	}
+
+	if wait > 0 && !r.delay {
+		return 0, false
+	}
+
+	if r.lines != nil {
+		r.lines.tokens -= lines
+	}
+	if r.bytes != nil {
+		r.bytes.tokens -= bytes
+	}
+
+	return wait, true
+}
+
+// admitLine applies each limiter in turn to a line of size bytes, sleeping
+// for limiters that delay. It returns false if the line should be dropped
+// or stop was closed while waiting.
+func admitLine(size int, stop <-chan struct{}, limiters ...*rateLimiter) bool {
+	for _, r := range limiters {
+		if r == nil {
+			continue
+		}
+
+		wait, ok := r.reserve(size)
+		if !ok {
+			return false
+		}
+
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-stop:
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitDrop(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newRateLimiter(RateLimit{}))
+
+	r := newRateLimiter(RateLimit{LinesPerSecond: 1, LineBurst: 3})
+	for i := 0; i < 3; i++ {
+		assert.True(admitLine(10, nil, r), "Expected line %d to be within the burst", i)
+	}
+	assert.False(admitLine(10, nil, r), "Expected line past the burst to be dropped")
+
+	// a nil limiter doesn't limit anything
+	assert.True(admitLine(10, nil, nil))
+}
+
+func TestRateLimitBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newRateLimiter(RateLimit{BytesPerSecond: 100})
+	assert.True(admitLine(60, nil, r))
+	assert.False(admitLine(60, nil, r))
+
+	// lines larger than the burst are let through once the bucket is full
+	r = newRateLimiter(RateLimit{BytesPerSecond: 100})
+	assert.True(admitLine(1000, nil, r))
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newRateLimiter(RateLimit{LinesPerSecond: 20, LineBurst: 1, Delay: true})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.True(admitLine(10, nil, r))
+	}
+	assert.True(time.Since(start) >= 90*time.Millisecond, "Expected lines to be delayed, took %s", time.Since(start))
+
+	// closing stop abandons the wait
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(admitLine(10, stop, r))
+}
+
+func TestRateLimitGlobal(t *testing.T) {
+	assert := assert.New(t)
+
+	file := newRateLimiter(RateLimit{LinesPerSecond: 100})
+	global := newRateLimiter(RateLimit{LinesPerSecond: 1, LineBurst: 1})
+
+	assert.True(admitLine(10, nil, file, global))
+	assert.False(admitLine(10, nil, file, global))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const defaultRedactReplacement = "[REDACTED]"
+
+// A RedactRule replaces every match of Pattern in a line before it is
+// forwarded. Replacement may refer to submatches like regexp.Expand.
+type RedactRule struct {
+	Name        string
+	Pattern     *regexp.Regexp
+	Replacement string
+
+	// Detector names the built-in detector the rule came from, if any
+	Detector string
+}
+
+type redactDetector struct {
+	pattern     string
+	replacement string
+
+	// validate rejects matches that only look like a secret
+	validate func(match string) bool
+}
+
+// built-in detectors for common sensitive values
+var redactDetectors = map[string]redactDetector{
+	"credit_card": {
+		pattern:  `\b\d(?:[ -]?\d){12,18}\b`,
+		validate: cardNumber,
+	},
+	"jwt": {
+		pattern: `\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+	},
+	"aws_access_key": {
+		pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	},
+	"email": {
+		pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	},
+	"bearer_token": {
+		pattern:     `(?i)(bearer\s+)[A-Za-z0-9._~+/-]+=*`,
+		replacement: "${1}" + defaultRedactReplacement,
+	},
+}
+
+// the prefixes card networks issue numbers under, from and to inclusive,
+// and how many digits their numbers have
+var cardPrefixes = []struct {
+	from, to       string
+	minLen, maxLen int
+}{
+	{"4", "4", 13, 19},       // Visa
+	{"51", "55", 16, 16},     // Mastercard
+	{"2221", "2720", 16, 16}, // Mastercard
+	{"34", "34", 15, 15},     // American Express
+	{"37", "37", 15, 15},     // American Express
+	{"300", "305", 14, 19},   // Diners Club
+	{"36", "36", 14, 19},     // Diners Club
+	{"3528", "3589", 16, 19}, // JCB
+	{"6011", "6011", 16, 19}, // Discover
+	{"644", "649", 16, 19},   // Discover
+	{"65", "65", 16, 19},     // Discover
+	{"62", "62", 16, 19},     // UnionPay
+}
+
+// cardNumber reports whether s looks like a payment card number: one
+// starting with a card network's prefix, as long as that network's are,
+// that passes the Luhn check. A tenth of other numbers, like timestamps
+// in milliseconds, pass the Luhn check alone.
+func cardNumber(s string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, s)
+
+	for _, p := range cardPrefixes {
+		if len(digits) < p.minLen || len(digits) > p.maxLen {
+			continue
+		}
+		if prefix := digits[:len(p.from)]; prefix >= p.from && prefix <= p.to {
+			return luhn(digits)
+		}
+	}
+	return false
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum used by
+// payment card numbers
+func luhn(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+
+	return n > 0 && sum%10 == 0
+}
+
+// apply replaces every match in line, returning the result and how many
+// matches were replaced
+func (r *RedactRule) apply(line string) (string, int) {
+	matches := r.Pattern.FindAllStringSubmatchIndex(line, -1)
+	if matches == nil {
+		return line, 0
+	}
+
+	validate := redactDetectors[r.Detector].validate
+
+	var (
+		b     strings.Builder
+		last  int
+		count int
+	)
+	for _, m := range matches {
+		if validate != nil && !validate(line[m[0]:m[1]]) {
+			continue
+		}
+
+		b.WriteString(line[last:m[0]])
+		b.Write(r.Pattern.ExpandString(nil, r.Replacement, line, m))
+		last = m[1]
+		count++
+	}
+	b.WriteString(line[last:])
+
+	return b.String(), count
+}
+
+// redactLine applies each rule in order
+func redactLine(line string, rules []*RedactRule) string {
+	for _, r := range rules {
+		var n int
+		if line, n = r.apply(line); n > 0 {
+			redactions.Add(r.Name, int64(n))
+		}
+	}
+	return line
+}
+
+func decodeRedactRules(f interface{}) ([]*RedactRule, error) {
+	vals, ok := f.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid input type for redact rules: %#v", f)
+	}
+
+	rules := make([]*RedactRule, len(vals))
+	for i, v := range vals {
+		var (
+			name, pattern, replacement, detector string
+			hasReplacement                       bool
+		)
+
+		switch val := v.(type) {
+		case string:
+			// a bare string names a detector
+			detector = val
+
+		case map[interface{}]interface{}, map[string]interface{}:
+			// JSON config files decode to string keys, YAML ones don't
+			m := stringKeys(val)
+			name, _ = m["name"].(string)
+			pattern, _ = m["pattern"].(string)
+			detector, _ = m["detector"].(string)
+			replacement, hasReplacement = m["replacement"].(string)
+
+		default:
+			return nil, fmt.Errorf("Invalid redact rule %#v", v)
+		}
+
+		if detector != "" {
+			d, ok := redactDetectors[detector]
+			if !ok {
+				return nil, fmt.Errorf("Unknown redact detector %q", detector)
+			}
+			if pattern != "" {
+				return nil, fmt.Errorf("Redact rule %q can't have both a detector and a pattern", detector)
+			}
+
+			pattern = d.pattern
+			if !hasReplacement {
+				replacement = d.replacement
+			}
+			if name == "" {
+				name = detector
+			}
+		}
+
+		if pattern == "" {
+			return nil, fmt.Errorf("Redact rule %#v needs a pattern or a detector", v)
+		}
+
+		exp, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			name = pattern
+		}
+		if replacement == "" && !hasReplacement {
+			replacement = defaultRedactReplacement
+		}
+
+		rules[i] = &RedactRule{
+			Name:        name,
+			Pattern:     exp,
+			Replacement: replacement,
+			Detector:    detector,
+		}
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/howbazaar/loggo"
+)
+
+var errNotRunning = errors.New("not running")
+
+// Reload stops tailing, switches to a new configuration and starts tailing
+// again with it. Files that are still configured carry on from where they
+// were left, with their new settings, and files that no longer are stop
+// being forwarded. The connection isn't remade, so changes to the
+// destination and how to reach it only take effect on restart.
+func (s *Server) Reload(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if !s.started || s.stopped || s.reloading {
+		s.mu.Unlock()
+		return errNotRunning
+	}
+	s.reloading = true
+	close(s.stopChan)
+	s.mu.Unlock()
+
+	log.Infof("Reloading the configuration")
+	// tailFiles sends READY=1 again once it has been through the globs
+	sdNotify("RELOADING=1")
+
+	// tailers stop once the line they're on has been handed to the logger
+	s.tailers.Wait()
+	s.saveOffsets()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reloading = false
+	if s.stopped {
+		// Close came in meanwhile, and has taken over
+		return errNotRunning
+	}
+
+	// the token is sent with each packet, so a new one is used straight
+	// away
+	old, new := s.config.Destination, c.Destination
+	if old.Host != new.Host || old.Port != new.Port || old.Protocol != new.Protocol {
+		log.Warningf("The destination only changes on restart")
+	}
+	if s.config.Health.Listen != c.Health.Listen {
+		log.Warningf("The health check listener only changes on restart")
+	}
+
+	// loggers no longer given a level go back to the default, as they would
+	// on startup
+	if s.config.LogLevels != c.LogLevels {
+		loggo.ResetLoggers()
+		loggo.ConfigureLoggers(c.LogLevels)
+	}
+	if s.config.LogFormat != c.LogFormat {
+		setLogFormat(c.LogFormat)
+	}
+
+	s.config = c
+	if s.ownLog != nil {
+		s.ownLog.configure(c)
+	}
+	s.limiter = newRateLimiter(c.RateLimit)
+	s.resume = true
+	s.stopChan = make(chan struct{})
+
+	s.tailers.Add(1)
+	go s.tailFiles()
+
+	log.Infof("Reloaded the configuration: forwarding %d globs", len(c.Files))
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/howbazaar/loggo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	assert := assert.New(t)
+
+	a := createFile(t, tmpdir+"/reload-a.txt")
+	defer os.Remove(a.Name())
+	defer a.Close()
+
+	b := createFile(t, tmpdir+"/reload-b.txt")
+	defer os.Remove(b.Name())
+	defer b.Close()
+
+	config := testConfig()
+	config.Files = []LogFile{{Path: a.Name(), Tag: "before"}}
+
+	s := NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	time.Sleep(1 * time.Second)
+
+	writeLog(a, "reload first")
+	packet, received := receivePacket("reload first")
+	if assert.True(received) {
+		assert.Equal("before", packet.Tag)
+	}
+
+	// the file carries on from where it was, with its new settings, and
+	// the new file is picked up
+	config = testConfig()
+	config.Files = []LogFile{{Path: a.Name(), Tag: "after"}, {Path: b.Name()}}
+	assert.NoError(s.Reload(config))
+
+	time.Sleep(1 * time.Second)
+
+	writeLog(a, "reload second")
+	packet, received = receivePrefixed("reload ")
+	if assert.True(received) {
+		assert.Equal("reload second", packet.Message, "Expected nothing to be sent twice")
+		assert.Equal("after", packet.Tag)
+	}
+
+	writeLog(b, "reload third")
+	_, received = receivePacket("reload third")
+	assert.True(received)
+
+	// a file that's no longer configured stops being forwarded
+	config = testConfig()
+	config.Files = []LogFile{{Path: b.Name()}}
+	assert.NoError(s.Reload(config))
+
+	time.Sleep(1 * time.Second)
+
+	writeLog(a, "reload dropped")
+	writeLog(b, "reload fourth")
+
+	var messages []string
+	timeout := time.After(2 * time.Second)
+	for done := false; !done; {
+		select {
+		case packet := <-server.packets:
+			messages = append(messages, packet.Message)
+		case <-timeout:
+			done = true
+		}
+	}
+	assert.Equal([]string{"reload fourth"}, messages)
+
+	// an invalid configuration is refused, leaving the old one in place
+	config = testConfig()
+	config.NewFileCheckInterval = 0
+	assert.Error(s.Reload(config))
+
+	writeLog(b, "reload fifth")
+	_, received = receivePacket("reload fifth")
+	assert.True(received)
+
+	// the destination only changes on restart, so events about it still
+	// name the one connected to
+	dest := s.destinationFields(nil)[fieldDestination]
+	config = testConfig()
+	config.Files = []LogFile{{Path: b.Name()}}
+	config.Destination.Port++
+	assert.NoError(s.Reload(config))
+	assert.Equal(dest, s.destinationFields(nil)[fieldDestination])
+
+	// our own log's levels and format change straight away
+	defer setLogFormat(LogFormatText)
+	config = testConfig()
+	config.Files = []LogFile{{Path: b.Name()}}
+	config.LogLevels = "<root>=INFO;reload=DEBUG"
+	config.LogFormat = LogFormatJSON
+	assert.NoError(s.Reload(config))
+	assert.Equal(loggo.DEBUG, loggo.GetLogger("reload").LogLevel())
+
+	w, err := loggo.ReplaceDefaultWriter(&loggo.TestWriter{})
+	if assert.NoError(err) {
+		assert.True(w.(maskingWriter).Writer.(*logWriter).json)
+	}
+
+	// and a logger that's no longer given a level goes back to the default
+	config = testConfig()
+	config.Files = []LogFile{{Path: b.Name()}}
+	assert.NoError(s.Reload(config))
+	assert.Equal(loggo.UNSPECIFIED, loggo.GetLogger("reload").LogLevel())
+}
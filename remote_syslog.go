@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -23,16 +26,88 @@ type Server struct {
 	config   *Config
 	logger   *syslog.Logger
 	registry WorkerRegistry
+	offsets  *OffsetStore
+	limiter  *rateLimiter
+	tailers  sync.WaitGroup
 	stopChan chan struct{}
 	stopped  bool
 	mu       sync.RWMutex
+
+	// dialCtx is cancelled by Close, so a connection still being made on
+	// startup doesn't hold up shutting down
+	dialCtx    context.Context
+	cancelDial context.CancelFunc
+
+	// destination is the host:port dial connects to. The destination only
+	// changes on restart, so it's kept from the config Reload replaces.
+	destination string
+
+	// compressed files that have been read, so they aren't decompressed
+	// again on every glob check
+	compressed map[string]os.FileInfo
+
+	// closed is closed once Close has finished, and abandoned is how many
+	// packets it gave up on
+	closed    chan struct{}
+	abandoned int
+
+	// dryRun is set instead of logger when packets are printed rather
+	// than sent
+	dryRun *dryRun
+
+	// started is set once Start is tailing, reloading while Reload has
+	// stopped tailing, and resume once it has, so files are picked up
+	// where they were left
+	started   bool
+	reloading bool
+	resume    bool
+
+	// health serves the health checks, which report when tailFiles last
+	// looked for new files, how each file's offset has moved and since
+	// when the queue has been backed up
+	health     *http.Server
+	lastGlob   time.Time
+	progress   progress
+	queuedOver time.Time
+
+	// sdStatus is the last status sent to systemd, and disconnected
+	// whether the connection was last seen down
+	sdStatus     string
+	disconnected bool
+
+	// debugLog is where a daemon's output goes
+	debugLog *debugLog
+
+	// ownLog sends our own log records to the destination
+	ownLog *ownLogWriter
+
+	// globbed is closed after the first pass over the globs, and opening
+	// waits for the tailers it started to open their files, so privileges
+	// can be dropped after. unreadableFiles have been reported as
+	// unreadable since.
+	globbed           chan struct{}
+	globbedOnce       sync.Once
+	opening           sync.WaitGroup
+	droppedPrivileges bool
+	unreadableFiles   map[string]bool
 }
 
 func NewServer(config *Config) *Server {
+	dialCtx, cancelDial := context.WithCancel(context.Background())
+
 	return &Server{
+		dialCtx:    dialCtx,
+		cancelDial: cancelDial,
+
 		config:   config,
 		registry: NewInMemoryRegistry(),
+		offsets:  NewOffsetStore(),
 		stopChan: make(chan struct{}),
+		closed:   make(chan struct{}),
+		globbed:  make(chan struct{}),
+
+		compressed:      make(map[string]os.FileInfo),
+		unreadableFiles: make(map[string]bool),
 	}
 }
 
@@ -41,126 +116,569 @@ func (s *Server) Start() error {
 		return err
 	}
 
-	if !s.config.NoDetach {
-		utils.Daemonize(s.config.DebugLogFile, s.config.PidFile)
+	if !s.config.NoDetach && !s.config.DryRun {
+		debugLog, err := openDebugLog(utils.ResolvePath(s.config.DebugLogFile), s.config.DebugLogMaxSize, s.config.DebugLogKeep)
+		if err != nil {
+			return fmt.Errorf("Could not open local log file: %v", err)
+		}
+
+		utils.Daemonize(debugLog, s.config.PidFile)
+
+		s.mu.Lock()
+		s.debugLog = debugLog
+		s.mu.Unlock()
 	}
 
 	loggo.ConfigureLoggers(s.config.LogLevels)
 
-	raddr := net.JoinHostPort(s.config.Destination.Host, strconv.Itoa(s.config.Destination.Port))
-	log.Infof("Connecting to %s over %s", raddr, s.config.Destination.Protocol)
-
-	var err error
-	s.logger, err = syslog.Dial(
-		s.config.Hostname,
-		s.config.Destination.Protocol,
-		raddr, s.config.RootCAs,
-		s.config.ConnectTimeout,
-		s.config.WriteTimeout,
-		s.config.TcpMaxLineLength,
-	)
-	if err != nil {
-		log.Errorf("Initial connection to server failed: %v - connection will be retried", err)
+	s.limiter = newRateLimiter(s.config.RateLimit)
+
+	if s.config.StateFile != "" {
+		if err := s.offsets.Load(s.config.StateFile); err != nil {
+			log.Errorf("Failed to load offsets from %s: %s", s.config.StateFile, err)
+		}
+	}
+
+	if err := s.dial(); err != nil {
+		return err
 	}
 
+	s.mu.Lock()
+	if s.stopped || s.dialCtx.Err() != nil {
+		// Close has run, or is running, since it stopped the dial
+		s.mu.Unlock()
+		<-s.closed
+		return nil
+	}
+	logger := s.logger
+	if logger != nil && s.config.OwnLog.Level != "" {
+		s.forwardOwnLog()
+	}
+	s.started = true
+	s.tailers.Add(1)
 	go s.tailFiles()
+	s.mu.Unlock()
+
+	if s.config.dropsPrivileges() {
+		if err := s.dropPrivileges(); err != nil {
+			return err
+		}
+	}
 
-	for err = range s.logger.Errors {
-		log.Errorf("Syslog error: %v", err)
+	s.serveHealth()
+
+	if interval := utils.SdWatchdogInterval(); interval > 0 {
+		log.Infof("Pinging the systemd watchdog every %s", interval)
+		go s.watchdog(interval)
+	}
+
+	if s.dryRun != nil {
+		<-s.closed
+		return nil
 	}
 
+	for err := range logger.Errors {
+		logEvent(loggo.ERROR, eventDestinationError, s.destinationFields(err), "Syslog error: %v", err)
+	}
+
+	// the logger is closed part way through Close, which saves the
+	// offsets after
+	<-s.closed
 	return nil
 }
 
-func (s *Server) Close() {
+// dial creates the logger, or in a dry run the sink that prints packets
+// instead. If the destination can't be reached the logger keeps trying to
+// connect, so it only fails if the options are unusable. If Close stops
+// it, there's no logger and no error.
+func (s *Server) dial() error {
+	if s.config.DryRun {
+		log.Infof("Dry run: printing packets instead of sending them")
+		s.dryRun = newDryRun(dryRunOutput, s.config)
+		return nil
+	}
+
+	raddr := net.JoinHostPort(s.config.Destination.Host, strconv.Itoa(s.config.Destination.Port))
+	s.destination = raddr
+	logEvent(loggo.INFO, eventConnecting, s.destinationFields(nil), "Connecting to %s over %s", raddr, s.config.Destination.Protocol)
+
+	// held while dialing, so Close either sees the logger or stops the
+	// dial, which it cancels before waiting for the lock
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if !s.stopped {
-		s.stopped = true
-		s.stopChan <- struct{}{}
+	if s.stopped {
+		return nil
+	}
+
+	logger, err := syslog.DialContext(s.dialCtx, syslog.Options{
+		Network:        s.config.Destination.Protocol,
+		Address:        raddr,
+		ClientHostname: s.config.Hostname,
+		RootCAs:        s.config.RootCAs,
+		ConnectTimeout: s.config.ConnectTimeout,
+		WriteTimeout:   s.config.WriteTimeout,
+		MaxLength:      s.config.TcpMaxLineLength,
+	})
+	switch {
+	case logger == nil && s.dialCtx.Err() != nil:
+		log.Infof("Stopped connecting to %s to shut down", raddr)
+		return nil
+	case logger == nil:
+		return err
+	case err != nil:
+		logEvent(loggo.ERROR, eventConnectFailed, s.destinationFields(err), "Initial connection to server failed: %v - connection will be retried", err)
+	}
+	s.logger = logger
+	return nil
+}
+
+// destinationFields are the log fields of events about the destination
+func (s *Server) destinationFields(err error) logFields {
+	fields := logFields{
+		fieldDestination: s.destination,
+	}
+	if err != nil {
+		fields[fieldError] = err
+	}
+	return fields
+}
+
+// logConnection logs the connection being lost or coming back, as seen
+// since the last call
+func (s *Server) logConnection() {
+	if s.logger == nil {
+		return
+	}
+
+	since := s.logger.DisconnectedSince()
+	switch {
+	case !since.IsZero() && !s.disconnected:
+		logEvent(loggo.WARNING, eventDisconnected, s.destinationFields(nil), "Disconnected from the destination at %s", since.Format(time.RFC3339))
+	case since.IsZero() && s.disconnected:
+		logEvent(loggo.INFO, eventReconnected, s.destinationFields(nil), "Reconnected to the destination")
+	}
+	s.disconnected = !since.IsZero()
+}
+
+// Close stops every tailer and then drains the packets still queued in
+// the logger, abandoning whatever is left once ShutdownTimeout has passed.
+// Calls after the first wait for it to finish.
+func (s *Server) Close() {
+	// before taking the lock, which dial holds
+	s.cancelDial()
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		<-s.closed
+		return
+	}
+	s.stopped = true
+	if !s.reloading {
+		close(s.stopChan)
+	}
+	logger := s.logger
+	s.mu.Unlock()
+
+	defer close(s.closed)
+	defer s.closeHealth()
+
+	log.Infof("Shutting down...")
+	sdNotify("STOPPING=1")
+	deadline := time.Now().Add(s.config.ShutdownTimeout)
+
+	if !s.waitTailers(deadline) {
+		log.Warningf("Timed out waiting for files to stop tailing")
+	}
+
+	if logger != nil {
+		s.stopOwnLog()
+		flushed, abandoned := logger.Drain(time.Until(deadline))
+		logEvent(loggo.INFO, eventPacketsFlushed, logFields{fieldCount: flushed, fieldAbandoned: abandoned}, "Flushed %d queued packets, abandoned %d", flushed, abandoned)
+		s.abandoned = abandoned
+
+		logger.Close()
+	}
+
+	// only once the queue is drained do the offsets take in the lines it
+	// wrote, and none of those it abandoned
+	for file, offset := range s.offsets.All() {
+		logEvent(loggo.INFO, eventFileStopped, logFields{fieldPath: file, fieldOffset: offset}, "Stopped forwarding %s at offset %d", file, offset)
+	}
+	s.saveOffsets()
+
+	redactions.Do(func(kv expvar.KeyValue) {
+		log.Infof("Redact rule %s made %s redactions", kv.Key, kv.Value)
+	})
+}
+
+// ReopenDebugLog reopens the debug log file, so a daemon's output goes to
+// a new file once logrotate has moved the old one away
+func (s *Server) ReopenDebugLog() {
+	s.mu.RLock()
+	debugLog := s.debugLog
+	s.mu.RUnlock()
+
+	if debugLog == nil {
+		return
+	}
+
+	if err := debugLog.Reopen(); err != nil {
+		log.Errorf("Failed to reopen %s: %s", debugLog.path, err)
+		return
+	}
+	log.Infof("Reopened %s", debugLog.path)
+}
+
+// waitTailers waits for tailFiles and all tailOne goroutines to return, giving up at
+// the deadline. It returns false if the deadline was reached.
+func (s *Server) waitTailers(deadline time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		s.tailers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// saveOffsets writes the offsets to the state file, if there is one, so
+// files using start_position saved can resume from them. A dry run sends
+// nothing, so doesn't save how far it got.
+func (s *Server) saveOffsets() {
+	if s.config.StateFile == "" || s.config.DryRun {
+		return
+	}
 
-		log.Infof("Shutting down...")
-		s.logger.Close()
+	if err := s.offsets.Save(s.config.StateFile); err != nil {
+		log.Errorf("Failed to save offsets to %s: %s", s.config.StateFile, err)
 	}
 }
 
+// closing reports whether tailing should stop, for Close or Reload
 func (s *Server) closing() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return s.stopped
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+		return false
+	}
 }
 
-// Tails a single file
-func (s *Server) tailOne(file, tag string, whence int) {
+// How following a file ended
+type followResult int
+
+const (
+	followStopped followResult = iota
+	followRotated
+	followTruncated
+)
+
+// Tails a single file. When the file is rotated away, having been read to
+// the end, its replacement is followed from the beginning. When it's
+// truncated, on_truncate decides. opened is called once the file is open,
+// or tailing it has failed.
+func (s *Server) tailOne(file string, lf LogFile, pos StartPosition, opened func()) {
+	defer s.tailers.Done()
 	defer s.registry.Remove(file)
+	defer opened()
 
-	t, err := follower.New(file, follower.Config{
-		Reopen: true,
-		Offset: 0,
-		Whence: whence,
-	})
+	p := s.newPipeline(file, lf)
+	defer p.end()
+
+	if isCompressed(file) {
+		fi, err := os.Stat(file)
+		if err != nil {
+			log.Errorf("%s", err)
+			return
+		}
 
+		s.readCompressed(file, pos, p)
+
+		s.mu.Lock()
+		s.compressed[file] = fi
+		s.mu.Unlock()
+		return
+	}
+
+	offset, err := s.startOffset(file, pos, p)
 	if err != nil {
 		log.Errorf("%s", err)
 		return
 	}
 
-	if tag == "" {
-		tag = path.Base(file)
+	// lines dropped by the file's or the global rate limit are counted and
+	// reported downstream every RateLimitSummary
+	var summary <-chan time.Time
+	if p.rateLimited() {
+		interval := s.config.RateLimitSummary
+		if interval <= 0 {
+			interval = defaultRateLimitSummaryInterval
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		summary = ticker.C
 	}
 
-	for {
-		select {
-		case line, ok := <-t.Lines():
-			if !ok {
-				if t.Err() != nil {
-					log.Errorf("%s", t.Err())
-				}
+	// repeats of the last line are collapsed into a single summary, which
+	// is sent once the window has passed or a different line arrives
+	var dedupTick <-chan time.Time
+	if p.dedup != nil {
+		ticker := time.NewTicker(lf.Dedup.Window)
+		defer ticker.Stop()
+		dedupTick = ticker.C
+	}
 
-				return
+	onTruncate := s.onTruncate(lf)
+
+	// the follower goes back to the start of a file that shrinks when it
+	// notices, and may not notice a file being replaced, so we check
+	// ourselves too
+	fileCheck := time.NewTicker(fileCheckInterval)
+	defer fileCheck.Stop()
+
+	// follow reads the file until it is rotated away or truncated, or
+	// tailing should stop
+	follow := func(offset int64) followResult {
+		t, err := follower.New(file, follower.Config{
+			Offset: offset,
+			Whence: io.SeekStart,
+		})
+
+		if err != nil {
+			log.Errorf("%s", err)
+			return followStopped
+		}
+
+		// track how far into the file we've read, so the lines sent can
+		// be reported on shutdown and saved in the state file
+		s.offsets.Set(file, offset)
+		fp, _ := s.offsets.Fingerprint(file)
+
+		// hold on to the file, so whatever is written to it after it's
+		// rotated away can still be read
+		old, err := os.Open(file)
+		if err != nil {
+			stopFollower(t)
+			log.Errorf("%s", err)
+			return followStopped
+		}
+		defer old.Close()
+		opened()
+
+		// truncated checks whether the file has shrunk below what we've
+		// read. When restarting, the follower is stopped, so any lines
+		// it read before noticing are sent from the copy instead.
+		truncated := func() bool {
+			fi, err := old.Stat()
+			if err != nil || fi.Size() >= offset {
+				return false
 			}
 
-			if s.closing() {
-				t.Close()
-				return
+			s.reportTruncation(file, offset, fi.Size(), onTruncate)
+
+			if onTruncate == TruncateRestart {
+				stopFollower(t)
+				return true
 			}
 
-			if d := line.Discarded(); d > 0 {
-				log.Infof("Discarded %d NULL bytes", d)
+			offset = 0
+			s.offsets.Set(file, offset)
+			if head, err := readHead(file); err == nil {
+				fp = newFingerprint(head)
+				s.offsets.SetFingerprint(file, fp)
+			}
+			return false
+		}
+
+		// replaced checks whether the file has been replaced without the
+		// follower noticing, which happens when it's deleted while still
+		// open elsewhere. Whatever is left in the old file is sent first.
+		replaced := func() bool {
+			cur, err := os.Stat(file)
+			if err != nil {
+				return false
 			}
+			fi, err := old.Stat()
+			if err != nil || os.SameFile(cur, fi) {
+				return false
+			}
+
+			stopFollower(t)
+			s.offsets.Set(file, s.readRotated(old, offset, p))
+			return true
+		}
+
+		log.Debugf("Starting %s at offset %d (%s)", file, offset, pos)
+
+		for {
+			select {
+			case line, ok := <-t.Lines():
+				if !ok {
+					// the follower stops without an error when the file is
+					// renamed or removed
+					if err := t.Err(); err != nil && !os.IsNotExist(err) {
+						log.Errorf("%s", err)
+						return followStopped
+					}
+
+					// the replacement usually appears straight away, and
+					// by then whatever was being written to the old file
+					// should have been
+					s.waitForFile(file)
+					s.offsets.Set(file, s.readRotated(old, offset, p))
+					return followRotated
+				}
+
+				if s.closing() {
+					stopFollower(t)
+					return followStopped
+				}
+
+				if truncated() {
+					return followTruncated
+				}
+
+				if d := line.Discarded(); d > 0 {
+					log.Infof("Discarded %d NULL bytes", d)
+				}
+
+				l := line.String()
+
+				start := offset
+				offset += int64(len(line.Bytes()) + 1 + line.Discarded())
 
-			l := line.String()
+				// identify the file by as much of it as we can, so it's
+				// recognized once it's rotated
+				if fp.Size < fingerprintSize && offset > int64(fp.Size) {
+					if head, err := readHead(file); err == nil {
+						fp = newFingerprint(head)
+						s.offsets.SetFingerprint(file, fp)
+					}
+				}
+
+				// the line is recorded as being sent before the offset
+				// moves past it, so it can't be saved as sent meanwhile
+				p.send(l, start)
+				s.offsets.Set(file, offset)
 
-			if !matchExps(l, s.config.ExcludePatterns) {
+			case <-summary:
+				p.reportSuppressed()
 
-				s.logger.Write(syslog.Packet{
-					Severity: s.config.Severity,
-					Facility: s.config.Facility,
-					Time:     time.Now(),
-					Hostname: s.logger.ClientHostname,
-					Tag:      tag,
-					Token:    s.config.Destination.Token,
-					Message:  l,
-				})
+			case now := <-dedupTick:
+				p.flushRepeats(now)
 
-				log.Tracef("Forwarding line: %s", l)
+			case <-fileCheck.C:
+				if replaced() {
+					return followRotated
+				}
+				if truncated() {
+					return followTruncated
+				}
 
-			} else {
-				log.Tracef("Not Forwarding line: %s", l)
+			case <-s.stopChan:
+				stopFollower(t)
+				return followStopped
 			}
+		}
+	}
 
-		case <-s.stopChan:
-			t.Close()
+	for {
+		switch follow(offset) {
+		case followRotated:
+			rotatedAt, _ := s.offsets.Get(file)
+			log.Infof("%s was rotated at offset %d", file, rotatedAt)
+			s.offsets.Rotated(file)
+
+			if _, err := os.Stat(file); err != nil {
+				logEvent(loggo.INFO, eventFileStopped, logFields{fieldPath: file, fieldOffset: rotatedAt}, "Stopped forwarding %s, which was removed", file)
+				return
+			}
+
+		case followTruncated:
+			// the old contents may have been copied away first, as
+			// logrotate's copytruncate does
+			s.offsets.Rotated(file)
+			s.catchUp(file, p)
+
+		default:
+			return
+		}
+
+		head, err := readHead(file)
+		if err != nil {
+			log.Errorf("%s", err)
 			return
 		}
+		s.offsets.SetFingerprint(file, newFingerprint(head))
+		offset = 0
+	}
+}
+
+// packet builds the syslog packet for a message from lf
+func (s *Server) packet(lf LogFile, message string) syslog.Packet {
+	hostname := lf.Hostname
+	if hostname == "" {
+		hostname = s.config.Hostname
+	}
+
+	return syslog.Packet{
+		Severity: s.config.Severity,
+		Facility: s.config.Facility,
+		Time:     time.Now(),
+		Hostname: hostname,
+		Tag:      lf.Tag,
+		Token:    s.config.Destination.Token,
+		Message:  message,
+		Fields:   lf.Fields,
 	}
 }
 
+// mergeFields returns the global fields overridden by the file's own
+func mergeFields(global, file map[string]string) map[string]string {
+	if len(file) == 0 {
+		return global
+	}
+	if len(global) == 0 {
+		return file
+	}
+
+	fields := make(map[string]string, len(global)+len(file))
+	for name, value := range global {
+		fields[name] = value
+	}
+	for name, value := range file {
+		fields[name] = value
+	}
+	return fields
+}
+
+// stopFollower closes a follower that may be blocked handing us a line;
+// the line is discarded so that Close can't deadlock.
+func stopFollower(t *follower.Follower) {
+	go func() {
+		for range t.Lines() {
+		}
+	}()
+
+	t.Close()
+}
+
 // Tails files speficied in the globs and re-evaluates the globs
 // at the specified interval
 func (s *Server) tailFiles() {
+	defer s.tailers.Done()
+
 	log.Debugf("Evaluating globs every %s", s.config.NewFileCheckInterval)
 	firstPass := true
 
@@ -170,17 +688,29 @@ func (s *Server) tailFiles() {
 		}
 
 		s.globFiles(firstPass)
-		time.Sleep(s.config.NewFileCheckInterval)
+		s.globbedOnce.Do(func() { close(s.globbed) })
+		s.saveOffsets()
+		s.notifyStatus(firstPass)
+		s.logConnection()
 		firstPass = false
+
+		s.mu.Lock()
+		s.lastGlob = time.Now()
+		s.mu.Unlock()
+
+		select {
+		case <-time.After(s.config.NewFileCheckInterval):
+		case <-s.stopChan:
+			return
+		}
 	}
 }
 
-//
+// Starts tailing any new files matching the globs
 func (s *Server) globFiles(firstPass bool) {
 	log.Debugf("Evaluating file globs")
 	for _, glob := range s.config.Files {
 
-		tag := glob.Tag
 		files, err := filepath.Glob(utils.ResolvePath(glob.Path))
 
 		if err != nil {
@@ -193,37 +723,112 @@ func (s *Server) globFiles(firstPass bool) {
 			switch {
 			case s.registry.Exists(file):
 				log.Debugf("Skipping %s because it is already running", file)
+			case s.compressedRead(file):
+				log.Debugf("Skipping %s because it was already read", file)
+			case s.unreadable(file):
+				log.Debugf("Skipping %s because it can't be read", file)
 			case matchExps(file, s.config.ExcludeFiles):
 				log.Debugf("Skipping %s because it is excluded by regular expression", file)
+				s.skipFile(file, ruleString("exclude_files", firstMatch(file, s.config.ExcludeFiles)))
+			case len(s.config.IncludeFiles) > 0 && !matchExps(file, s.config.IncludeFiles):
+				log.Debugf("Skipping %s because it doesn't match include_files", file)
+				s.skipFile(file, "no include_files match")
 			default:
-				log.Infof("Forwarding file: %s", file)
-
-				whence := io.SeekStart
-
-				// don't read the entire file on startup
-				if firstPass {
-					whence = io.SeekEnd
-				}
+				logEvent(loggo.INFO, eventFileStarted, logFields{fieldPath: file}, "Forwarding file: %s", file)
 
 				s.registry.Add(file)
-				go s.tailOne(file, tag, whence)
+				s.tailers.Add(1)
+				go s.tailOne(file, glob.forFile(file), s.startPosition(glob, file, firstPass), s.trackOpening())
 			}
 		}
 	}
 }
 
+// skipFile prints a file left out by exclude_files or include_files in a
+// dry run
+func (s *Server) skipFile(file, rule string) {
+	if s.dryRun != nil {
+		s.dryRun.skip(file, rule)
+	}
+}
+
+// compressedRead reports whether a compressed file has been read already,
+// and not replaced since
+func (s *Server) compressedRead(file string) bool {
+	s.mu.RLock()
+	read, ok := s.compressed[file]
+	s.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	fi, err := os.Stat(file)
+	return err == nil && os.SameFile(fi, read) && fi.Size() == read.Size() && fi.ModTime().Equal(read.ModTime())
+}
+
+// startPosition decides where to start a file found by the glob: the
+// file's own start_position, else the global one, else the end for files
+// found on startup, so we don't read the entire file, and the beginning
+// for files created since. After a reload, files that were being tailed
+// carry on from where they were.
+func (s *Server) startPosition(glob LogFile, file string, firstPass bool) StartPosition {
+	_, tailed := s.offsets.Get(file)
+
+	switch {
+	case s.resume && tailed:
+		return StartPosition{Mode: StartSaved}
+	case glob.StartPosition.Mode != "":
+		return glob.StartPosition
+	case s.config.StartPosition.Mode != "":
+		return s.config.StartPosition
+	case firstPass:
+		return StartPosition{Mode: StartEnd}
+	default:
+		return StartPosition{Mode: StartBeginning}
+	}
+}
+
 // Evaluates each regex against the string. If any one is a match
 // the function returns true, otherwise it returns false
 func matchExps(value string, expressions []*regexp.Regexp) bool {
+	return firstMatch(value, expressions) != nil
+}
+
+// Returns the first regex that matches the string, or nil
+func firstMatch(value string, expressions []*regexp.Regexp) *regexp.Regexp {
 	for _, exp := range expressions {
 		if exp.MatchString(value) {
-			return true
+			return exp
 		}
 	}
-	return false
+	return nil
+}
+
+// Decides whether a line from lf should be forwarded. exclude_patterns always
+// win; otherwise, if there are include patterns (the file's own, or else the
+// global ones) the line has to match one of them. The pattern that decided
+// is returned, which is nil when the line matched no include pattern.
+func (s *Server) filterLine(line string, lf LogFile) (bool, *regexp.Regexp) {
+	if exp := firstMatch(line, s.config.ExcludePatterns); exp != nil {
+		return false, exp
+	}
+
+	include := lf.IncludePatterns
+	if len(include) == 0 {
+		include = s.config.IncludePatterns
+	}
+	if len(include) == 0 {
+		return true, nil
+	}
+
+	exp := firstMatch(line, include)
+	return exp != nil, exp
 }
 
 func main() {
+	setLogFormat(LogFormatText)
+
 	c, err := NewConfigFromEnv()
 	if err != nil {
 		if err == ErrUsage {
@@ -234,9 +839,54 @@ func main() {
 		os.Exit(1)
 	}
 
+	setLogFormat(c.LogFormat)
+
+	if c.Command == "check-config" {
+		if err := c.Check(os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if start, err := c.Control(); err != nil {
+		log.Criticalf("Failed to %s: %v", c.Command, err)
+		os.Exit(1)
+	} else if !start {
+		return
+	}
+
 	utils.AddSignalHandlers()
 
 	s := NewServer(c)
+	utils.AddShutdownHandler(s.Close)
+
+	if c.Command == "send" {
+		summary, err := s.Send(c.Args)
+		if summary.files > 0 {
+			fmt.Println(summary)
+		}
+
+		if err != nil {
+			log.Criticalf("Failed to send: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	utils.AddReopenHandler(s.ReopenDebugLog)
+	utils.AddReloadHandler(func() {
+		c, err := NewConfigFromEnv()
+		if err == nil {
+			err = s.Reload(c)
+		}
+		if err != nil {
+			log.Errorf("Failed to reload the configuration, carrying on with the old one: %s", err)
+		}
+
+		// reloading is also a chance to pick up a rotated debug log
+		s.ReopenDebugLog()
+	})
+
 	if err = s.Start(); err != nil {
 		log.Criticalf("Failed to start server: %v", err)
 		os.Exit(255)
@@ -2,11 +2,15 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"expvar"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,13 +32,15 @@ var (
 // main testing function to clean up after running
 func TestMain(m *testing.M) {
 	os.Mkdir(tmpdir, 0755)
+	setLogFormat(LogFormatText)
 
 	server = newTestSyslogServer("127.0.0.1:0")
 	go server.serve()
 
 	rs := m.Run()
 
-	server.closeCh <- struct{}{}
+	close(server.closeCh)
+	server.listener.Close()
 
 	os.RemoveAll(tmpdir)
 	os.Exit(rs)
@@ -54,6 +60,85 @@ func TestFilters(t *testing.T) {
 	}
 }
 
+func TestIncludeFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	errors := regexp.MustCompile("ERROR")
+	warnings := regexp.MustCompile("WARN")
+	healthcheck := regexp.MustCompile("healthcheck")
+
+	s := NewServer(&Config{
+		ExcludePatterns: []*regexp.Regexp{healthcheck},
+		IncludePatterns: []*regexp.Regexp{errors},
+	})
+
+	tests := []struct {
+		line    string
+		lf      LogFile
+		forward bool
+		rule    *regexp.Regexp
+	}{
+		// global include patterns
+		{"ERROR disk full", LogFile{}, true, errors},
+		{"INFO all good", LogFile{}, false, nil},
+
+		// exclude patterns win over include patterns
+		{"ERROR healthcheck failed", LogFile{}, false, healthcheck},
+
+		// the file's include patterns replace the global ones
+		{"WARN disk filling", LogFile{IncludePatterns: []*regexp.Regexp{warnings}}, true, warnings},
+		{"ERROR disk full", LogFile{IncludePatterns: []*regexp.Regexp{warnings}}, false, nil},
+		{"WARN healthcheck slow", LogFile{IncludePatterns: []*regexp.Regexp{warnings}}, false, healthcheck},
+	}
+
+	for _, test := range tests {
+		forward, rule := s.filterLine(test.line, test.lf)
+		assert.Equal(test.forward, forward, "Filtering %q", test.line)
+		assert.Equal(test.rule, rule, "Filtering %q", test.line)
+	}
+
+	// without include patterns everything not excluded is forwarded
+	s = NewServer(&Config{})
+	forward, rule := s.filterLine("INFO all good", LogFile{})
+	assert.True(forward)
+	assert.Nil(rule)
+}
+
+func TestIncludeFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	config := testConfig()
+	config.IncludeFiles = []*regexp.Regexp{regexp.MustCompile(`included`)}
+	config.ExcludeFiles = []*regexp.Regexp{regexp.MustCompile(`excluded`)}
+
+	s := NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	for _, name := range []string{"other", "included-but-excluded", "included"} {
+		file, err := os.Create(fmt.Sprintf("tmp/%s-%d.log", name, time.Now().UnixNano()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		writeLog(file, "hello from "+name)
+	}
+
+	// only the file matching include_files and not exclude_files is sent
+	_, received := receivePacket("hello from included")
+	assert.True(received)
+
+	select {
+	case packet := <-server.packets:
+		t.Errorf("Unexpected packet %q", packet.Message)
+	case <-time.After(2 * time.Second):
+	}
+}
+
 func TestNewFileSeek(t *testing.T) {
 	assert := assert.New(t)
 
@@ -80,6 +165,422 @@ func TestNewFileSeek(t *testing.T) {
 	}
 }
 
+func TestStartPosition(t *testing.T) {
+	for i, tc := range []struct {
+		pos   StartPosition
+		first string
+	}{
+		{StartPosition{Mode: StartBeginning}, "one"},
+		{StartPosition{Mode: StartEnd}, "four"},
+		{StartPosition{Mode: StartLastLines, Count: 2}, "two"},
+		// exactly the line "start 3 three\n"
+		{StartPosition{Mode: StartLastBytes, Count: 14}, "three"},
+	} {
+		t.Run(tc.pos.String(), func(t *testing.T) {
+			file := tmpLogFile()
+			defer file.Close()
+
+			prefix := fmt.Sprintf("start %d ", i)
+			for _, msg := range []string{"one", "two", "three"} {
+				writeLog(file, prefix+msg)
+			}
+
+			config := testConfig()
+			config.Files = []LogFile{{Path: file.Name(), StartPosition: tc.pos}}
+
+			// lines already in the file are sent straight away, so listen
+			// before starting
+			first := make(chan syslog.Packet, 1)
+			go func() {
+				packet, _ := receivePrefixed(prefix)
+				first <- packet
+			}()
+
+			s := NewServer(config)
+			go s.Start()
+			defer s.Close()
+
+			// just a quick rest to get the server started
+			time.Sleep(1 * time.Second)
+
+			writeLog(file, prefix+"four")
+
+			assert.Equal(t, prefix+tc.first, (<-first).Message)
+		})
+	}
+}
+
+func TestStartPositionSaved(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpLogFile()
+	defer file.Close()
+	writeLog(file, "saved before")
+
+	config := testConfig()
+	config.StateFile = tmpdir + "/state.json"
+	config.Files = []LogFile{{Path: file.Name(), StartPosition: StartPosition{Mode: StartSaved}}}
+	defer os.Remove(config.StateFile)
+
+	// with nothing saved yet, the file is read from the end
+	s := NewServer(config)
+	go s.Start()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	writeLog(file, "saved first")
+	packet, received := receivePrefixed("saved ")
+	assert.True(received)
+	assert.Equal("saved first", packet.Message)
+
+	s.Close()
+
+	// lines written while stopped are forwarded after a restart
+	writeLog(file, "saved while stopped")
+
+	s = NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	packet, received = receivePrefixed("saved ")
+	assert.True(received)
+	assert.Equal("saved while stopped", packet.Message)
+}
+
+func TestStartPositionSavedUnsent(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpLogFile()
+	defer file.Close()
+	writeLog(file, "unsent one")
+	writeLog(file, "unsent two")
+
+	// nothing listens on the port, so nothing can be written
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	config := testConfig()
+	config.StateFile = tmpdir + "/unsent.json"
+	config.Destination.Port = l.Addr().(*net.TCPAddr).Port
+	config.ConnectTimeout = 100 * time.Millisecond
+	config.Files = []LogFile{{Path: file.Name(), StartPosition: StartPosition{Mode: StartBeginning}}}
+	defer os.Remove(config.StateFile)
+
+	s := NewServer(config)
+	go s.Start()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	offset, _ := s.offsets.Get(file.Name())
+	assert.NotZero(offset, "Expected the lines to have been read")
+	s.Close()
+
+	// the lines read but never written are sent after a restart
+	saved := NewOffsetStore()
+	assert.NoError(saved.Load(config.StateFile))
+	offset, ok := saved.Get(file.Name())
+	assert.True(ok)
+	assert.Equal(int64(0), offset)
+}
+
+func TestDefaultStartPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewServer(testConfig())
+	glob := s.config.Files[0]
+
+	assert.Equal(StartEnd, s.startPosition(glob, "app.log", true).Mode)
+	assert.Equal(StartBeginning, s.startPosition(glob, "app.log", false).Mode)
+
+	s.config.StartPosition = StartPosition{Mode: StartLastLines, Count: 10}
+	assert.Equal(s.config.StartPosition, s.startPosition(glob, "app.log", true))
+	assert.Equal(s.config.StartPosition, s.startPosition(glob, "app.log", false))
+
+	glob.StartPosition = StartPosition{Mode: StartEnd}
+	assert.Equal(glob.StartPosition, s.startPosition(glob, "app.log", false))
+
+	// after a reload, files that were being tailed carry on
+	s.resume = true
+	s.offsets.Set("app.log", 10)
+	assert.Equal(StartSaved, s.startPosition(glob, "app.log", true).Mode)
+	assert.Equal(glob.StartPosition, s.startPosition(glob, "other.log", true))
+}
+
+func TestStartOffsetSeen(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpLogFile()
+	defer file.Close()
+	writeLog(file, "starting")
+	writeLog(file, "new lines")
+
+	s := NewServer(testConfig())
+	beginning := StartPosition{Mode: StartBeginning}
+
+	// a short file seen before only shares the new file's first line
+	s.offsets.MarkSeen(newFingerprint([]byte("starting\n")), 9, false)
+	offset, err := s.startOffset(file.Name(), beginning, nil)
+	assert.NoError(err)
+	assert.Equal(int64(0), offset)
+
+	// a whole fingerprint is the same contents under another name
+	long := strings.Repeat("x", fingerprintSize)
+	writeLog(file, long)
+	head, _ := readHead(file.Name())
+	s.offsets.MarkSeen(newFingerprint(head), 20, false)
+	offset, err = s.startOffset(file.Name(), beginning, nil)
+	assert.NoError(err)
+	assert.Equal(int64(20), offset)
+}
+
+func TestRotation(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+
+		// rotate rotates file, returning the file to write to next
+		rotate func(t *testing.T, file *os.File) *os.File
+
+		truncated bool
+	}{
+		{
+			name: "rename",
+			rotate: func(t *testing.T, file *os.File) *os.File {
+				rotated := file.Name() + ".1"
+				assert.NoError(t, os.Rename(file.Name(), rotated))
+				t.Cleanup(func() { os.Remove(rotated) })
+
+				// written before the application reopens its log
+				writeLog(file, "rotation rest of the old file")
+
+				return createFile(t, file.Name())
+			},
+		},
+		{
+			name: "copytruncate",
+			rotate: func(t *testing.T, file *os.File) *os.File {
+				rotated := file.Name() + ".1"
+				data, err := ioutil.ReadFile(file.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.NoError(t, ioutil.WriteFile(rotated, data, 0644))
+				t.Cleanup(func() { os.Remove(rotated) })
+
+				assert.NoError(t, file.Truncate(0))
+				file.Seek(0, io.SeekStart)
+
+				return file
+			},
+			truncated: true,
+		},
+		{
+			name: "delete and recreate",
+			rotate: func(t *testing.T, file *os.File) *os.File {
+				assert.NoError(t, os.Remove(file.Name()))
+				return createFile(t, file.Name())
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			file := tmpLogFile()
+			defer file.Close()
+
+			config := testConfig()
+			config.Files = []LogFile{{Path: file.Name()}}
+
+			s := NewServer(config)
+			go s.Start()
+			defer s.Close()
+
+			// just a quick rest to get the server started
+			time.Sleep(1 * time.Second)
+
+			before := "rotation before, long enough to be longer than what follows"
+			writeLog(file, before)
+			_, received := receivePacket(before)
+			assert.True(received)
+
+			truncations.Set(file.Name(), new(expvar.Int))
+
+			next := tc.rotate(t, file)
+			if next != file {
+				defer next.Close()
+			}
+
+			// a quick rest so the rotation is noticed
+			time.Sleep(200 * time.Millisecond)
+			writeLog(next, "rotation after")
+
+			var got []string
+			for {
+				packet, ok := receivePrefixed("rotation ")
+				if !ok {
+					break
+				}
+				got = append(got, packet.Message)
+				if packet.Message == "rotation after" {
+					break
+				}
+			}
+
+			want := []string{"rotation after"}
+			if tc.name == "rename" {
+				want = []string{"rotation rest of the old file", "rotation after"}
+			}
+			assert.Equal(want, got, "Expected nothing lost or sent twice")
+
+			offset, _ := s.offsets.Get(file.Name())
+			assert.Equal(int64(len("rotation after\n")), offset)
+
+			var count int64
+			if tc.truncated {
+				count = 1
+			}
+			assert.Equal(count, truncations.Get(file.Name()).(*expvar.Int).Value())
+		})
+	}
+}
+
+func TestTruncateWarn(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpLogFile()
+	defer file.Close()
+
+	config := testConfig()
+	config.Files = []LogFile{{Path: file.Name(), OnTruncate: TruncateWarn}}
+
+	s := NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	writeLog(file, "warn before truncating")
+	_, received := receivePacket("warn before truncating")
+	assert.True(received)
+
+	assert.NoError(file.Truncate(0))
+	file.Seek(0, io.SeekStart)
+
+	// give the periodic check time to notice
+	time.Sleep(fileCheckInterval + 500*time.Millisecond)
+	assert.Equal(int64(1), truncations.Get(file.Name()).(*expvar.Int).Value())
+
+	offset, _ := s.offsets.Get(file.Name())
+	assert.Equal(int64(0), offset)
+
+	// the follower carries on from the beginning itself
+	writeLog(file, "warn after")
+	_, received = receivePacket("warn after")
+	assert.True(received)
+}
+
+func TestRotationCatchUp(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpLogFile()
+	defer file.Close()
+	writeLog(file, "catch up already sent")
+
+	config := testConfig()
+	config.StateFile = tmpdir + "/catchup.json"
+	config.Files = []LogFile{{Path: file.Name(), StartPosition: StartPosition{Mode: StartSaved}}}
+	defer os.Remove(config.StateFile)
+
+	s := NewServer(config)
+	go s.Start()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+	s.Close()
+
+	// while we're stopped, more is logged and the file is rotated and
+	// compressed
+	writeLog(file, "catch up missed")
+
+	rotated := file.Name() + ".1.gz"
+	compressLog(t, file.Name(), rotated)
+	defer os.Remove(rotated)
+
+	replacement, err := os.Create(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replacement.Close()
+	writeLog(replacement, "catch up new file")
+
+	packets := make(chan syslog.Packet, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			packet, _ := receivePrefixed("catch up ")
+			packets <- packet
+		}
+	}()
+
+	s = NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	assert.Equal("catch up missed", (<-packets).Message)
+	assert.Equal("catch up new file", (<-packets).Message)
+}
+
+func TestCompressedGlob(t *testing.T) {
+	assert := assert.New(t)
+
+	config := testConfig()
+	config.Files = []LogFile{{Path: tmpdir + "/*.log.gz"}}
+
+	s := NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	file := tmpLogFile()
+	writeLog(file, "compressed one")
+	writeLog(file, "compressed two")
+	file.Close()
+
+	compressed := file.Name() + ".gz"
+	defer os.Remove(compressed)
+
+	packets := make(chan syslog.Packet, 3)
+	go func() {
+		for {
+			packet, ok := receivePrefixed("compressed ")
+			if !ok {
+				close(packets)
+				return
+			}
+			packets <- packet
+		}
+	}()
+
+	compressLog(t, file.Name(), compressed)
+
+	assert.Equal("compressed one", (<-packets).Message)
+	assert.Equal("compressed two", (<-packets).Message)
+
+	// renaming it doesn't get it sent again
+	renamed := strings.TrimSuffix(file.Name(), ".log") + "-renamed.log.gz"
+	assert.NoError(os.Rename(compressed, renamed))
+	defer os.Remove(renamed)
+
+	_, again := <-packets
+	assert.False(again, "Expected the compressed file to be sent once")
+}
+
 func TestGlobCollisions(t *testing.T) {
 	assert := assert.New(t)
 
@@ -125,6 +626,250 @@ func TestGlobCollisions(t *testing.T) {
 	}
 }
 
+func TestCloseRecordsOffsets(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewServer(testConfig())
+	go s.Start()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	file := tmpLogFile()
+	defer file.Close()
+
+	msg := "all the small things"
+	writeLog(file, msg)
+
+	_, received := receivePacket(msg)
+	assert.True(received, "Expected to receive %q", msg)
+
+	s.Close()
+
+	offset, ok := s.offsets.Get(file.Name())
+	assert.True(ok, "Expected an offset to be recorded for %s", file.Name())
+	assert.Equal(int64(len(msg)+1), offset)
+
+	// closing twice is harmless
+	s.Close()
+}
+
+func TestCloseWhileConnecting(t *testing.T) {
+	// accepts connections but never completes a TLS handshake, so the
+	// first dial waits for ConnectTimeout
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	config := testConfig()
+	config.Destination.Protocol = "tls"
+	config.Destination.Port = ln.Addr().(*net.TCPAddr).Port
+	config.ConnectTimeout = time.Minute
+
+	s := NewServer(config)
+	started := make(chan error)
+	go func() {
+		started <- s.Start()
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	s.Close()
+
+	select {
+	case err := <-started:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Start to return once closed while connecting")
+	}
+}
+
+func TestStartWaitsForClose(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpLogFile()
+	defer file.Close()
+
+	config := testConfig()
+	config.StateFile = tmpdir + "/wait.json"
+	config.Files = []LogFile{{Path: file.Name()}}
+	defer os.Remove(config.StateFile)
+
+	s := NewServer(config)
+	started := make(chan error)
+	go func() {
+		started <- s.Start()
+	}()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+	go s.Close()
+
+	// main exits once Start returns, so Close must have saved the offsets
+	assert.NoError(<-started)
+	select {
+	case <-s.closed:
+	default:
+		t.Error("Expected Start to return once Close had finished")
+	}
+	_, err := os.Stat(config.StateFile)
+	assert.NoError(err)
+}
+
+func TestRateLimitSummary(t *testing.T) {
+	assert := assert.New(t)
+
+	config := testConfig()
+	config.RateLimitSummary = 1 * time.Second
+	config.Files[0].RateLimit = RateLimit{LinesPerSecond: 0.001, LineBurst: 1}
+
+	s := NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	file := tmpLogFile()
+	defer file.Close()
+
+	for i := 0; i < 5; i++ {
+		writeLog(file, "flood "+strconv.Itoa(i))
+	}
+
+	_, received := receivePacket("flood 0")
+	assert.True(received, "Expected the first line to be forwarded")
+
+	msg := fmt.Sprintf("4 lines suppressed from %s", file.Name())
+	_, received = receivePacket(msg)
+	assert.True(received, "Expected to receive %q", msg)
+}
+
+func TestPathPatternTag(t *testing.T) {
+	assert := assert.New(t)
+
+	config := testConfig()
+	config.Files[0].PathPattern = regexp.MustCompile(`tmp/(?P<name>\d+)\.log$`)
+	config.Files[0].Tag = "app-%{name}"
+	config.Files[0].Hostname = "%{name}.example.com"
+
+	s := NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	file := tmpLogFile()
+	defer file.Close()
+
+	msg := "tagged from the path"
+	writeLog(file, msg)
+
+	name := strings.TrimSuffix(strings.TrimPrefix(file.Name(), "tmp/"), ".log")
+	packet, received := receivePacket(msg)
+	assert.True(received)
+	assert.Equal("app-"+name, packet.Tag)
+	assert.Equal(name+".example.com", packet.Hostname)
+}
+
+func TestFields(t *testing.T) {
+	assert := assert.New(t)
+
+	config := testConfig()
+	config.Fields = map[string]string{"env": "test", "region": "moon-1"}
+	config.Files[0].Fields = map[string]string{"region": "mars-1"}
+
+	s := NewServer(config)
+	go s.Start()
+	defer s.Close()
+
+	// just a quick rest to get the server started
+	time.Sleep(1 * time.Second)
+
+	file := tmpLogFile()
+	defer file.Close()
+
+	msg := "with some fields"
+	writeLog(file, msg)
+
+	packet, received := receivePacket(msg)
+	assert.True(received)
+	assert.Equal(map[string]string{"env": "test", "region": "mars-1"}, packet.Fields)
+}
+
+// receivePacket waits for a packet carrying msg, skipping any left over
+// from earlier tests
+func receivePacket(msg string) (syslog.Packet, bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case packet := <-server.packets:
+			if packet.Message == msg {
+				return packet, true
+			}
+		case <-timeout:
+			return syslog.Packet{}, false
+		}
+	}
+}
+
+// receivePrefixed returns the next packet whose message starts with
+// prefix, skipping any left over from earlier tests
+func receivePrefixed(prefix string) (syslog.Packet, bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case packet := <-server.packets:
+			if strings.HasPrefix(packet.Message, prefix) {
+				return packet, true
+			}
+		case <-timeout:
+			return syslog.Packet{}, false
+		}
+	}
+}
+
+// createFile creates a file, closing it when the test ends
+func createFile(t *testing.T, name string) *os.File {
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// compressLog gzips a file the way logrotate does, removing the original
+func compressLog(t *testing.T, file, compressed string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(compressed + ".tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gz := gzip.NewWriter(f)
+	gz.Write(data)
+	gz.Close()
+	f.Close()
+
+	os.Rename(compressed+".tmp", compressed)
+	os.Remove(file)
+}
+
 // write to test log file
 func writeLog(file *os.File, msg string) {
 	w := bufio.NewWriterSize(file, 1024*32)
@@ -155,6 +900,7 @@ func testConfig() *Config {
 		ConnectTimeout:       10 * time.Second,
 		WriteTimeout:         10 * time.Second,
 		NewFileCheckInterval: 1 * time.Second,
+		ShutdownTimeout:      1 * time.Second,
 		LogLevels:            "<root>=INFO",
 		TcpMaxLineLength:     99990,
 		NoDetach:             true,
@@ -162,10 +908,11 @@ func testConfig() *Config {
 		Severity:             severity,
 		Facility:             facility,
 		Destination: struct {
-			Host     string
-			Port     int
-			Protocol string
-			Token    string
+			Host      string
+			Port      int
+			Protocol  string
+			Token     string
+			TokenFile string `mapstructure:"token_file"`
 		}{
 			Host:     addr.host,
 			Port:     addr.port,
@@ -235,10 +982,16 @@ func (s *testSyslogServer) serve() {
 		default:
 			conn, err := s.listener.Accept()
 			if err != nil {
-				panic(err)
+				select {
+				case <-s.closeCh:
+					return
+				default:
+					panic(err)
+				}
 			}
 
 			reader := bufio.NewReader(conn)
+		read:
 			for {
 				select {
 				case <-s.closeCh:
@@ -250,9 +1003,10 @@ func (s *testSyslogServer) serve() {
 						panic(err)
 					}
 
+					// the client hung up, wait for the next one
 					if err == io.EOF {
-						time.Sleep(100 * time.Millisecond)
-						continue
+						conn.Close()
+						break read
 					}
 
 					fmt.Printf(line)
@@ -297,3 +1051,14 @@ func (tr *testRegistry) Remove(worker string) {
 	log.Tracef("Removing %s from worker registry", worker)
 	delete(tr.workers, worker)
 }
+
+func (tr *testRegistry) List() []string {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	workers := make([]string, 0, len(tr.workers))
+	for worker := range tr.workers {
+		workers = append(workers, worker)
+	}
+	sort.Strings(workers)
+	return workers
+}
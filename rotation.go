@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/howbazaar/loggo"
+)
+
+// how long to wait for a rotated file to be replaced before giving up on it
+const reappearTimeout = 1 * time.Second
+
+// how often tailed files are checked for having been truncated or replaced,
+// in case the follower doesn't notice
+const fileCheckInterval = 1 * time.Second
+
+// startOffset decides where to start tailing a plain file. Contents we've
+// seen before under another name, like a rotated file found by a glob,
+// continue from where they were left, if their fingerprint is a whole
+// one: a shorter one could just as well be a new file's first line.
+// Otherwise the start position
+// decides, except that if start_position saved finds the file was rotated
+// while we weren't running, the rest of the old contents are caught up on
+// from its rotated siblings and the new file is read from the beginning.
+func (s *Server) startOffset(file string, pos StartPosition, p *pipeline) (int64, error) {
+	head, err := readHead(file)
+	if err != nil {
+		return 0, err
+	}
+	fp := newFingerprint(head)
+
+	if seen, ok := s.offsets.Seen(head); ok && seen.Fingerprint.Size == fingerprintSize {
+		log.Infof("%s was already forwarded up to offset %d", file, seen.Offset)
+		s.offsets.SetFingerprint(file, fp)
+		return seen.Offset, nil
+	}
+
+	saved, hasSaved := s.offsets.Get(file)
+
+	if pos.Mode == StartSaved && hasSaved {
+		if old, ok := s.offsets.Fingerprint(file); ok && !old.matches(head) {
+			log.Infof("%s was rotated since offset %d was saved", file, saved)
+
+			s.offsets.Rotated(file)
+			s.catchUp(file, p)
+
+			s.offsets.SetFingerprint(file, fp)
+			return 0, nil
+		}
+	}
+
+	s.offsets.SetFingerprint(file, fp)
+	return pos.offset(file, saved, hasSaved)
+}
+
+// catchUp sends the rest of a tracked file's old contents, which rotation
+// has moved to a sibling like app.log.1 or app.log.1.gz
+func (s *Server) catchUp(file string, p *pipeline) {
+	var siblings []string
+	for _, pattern := range []string{file + ".*", file + "-*"} {
+		matches, _ := filepath.Glob(pattern)
+		siblings = append(siblings, matches...)
+	}
+
+	for _, sibling := range siblings {
+		// a sibling a glob also matched is taken care of by its own tailer
+		if s.registry.Exists(sibling) {
+			continue
+		}
+
+		head, err := readHead(sibling)
+		if err != nil {
+			log.Debugf("Skipping %s: %s", sibling, err)
+			continue
+		}
+
+		seen, ok := s.offsets.Seen(head)
+		if !ok || seen.Complete {
+			continue
+		}
+
+		log.Infof("Catching up on %s from offset %d", sibling, seen.Offset)
+		s.readRest(sibling, head, seen.Offset, p)
+	}
+}
+
+// readCompressed sends a compressed file a glob matched. It can't grow, so
+// it is read once: from where its contents were left if we've seen them
+// before, else whole if files are started at the beginning. Either way the
+// contents are then marked as sent so they are never sent again.
+func (s *Server) readCompressed(file string, pos StartPosition, p *pipeline) {
+	head, err := readHead(file)
+	if err != nil {
+		log.Errorf("%s", err)
+		return
+	}
+	fp := newFingerprint(head)
+
+	seen, ok := s.offsets.Seen(head)
+	switch {
+	case ok && seen.Complete:
+		log.Debugf("Skipping %s because it was already forwarded", file)
+
+	case ok:
+		log.Infof("Catching up on %s from offset %d", file, seen.Offset)
+		s.readRest(file, head, seen.Offset, p)
+
+	case pos.Mode == StartBeginning:
+		logEvent(loggo.INFO, eventFileStarted, logFields{fieldPath: file}, "Forwarding compressed file: %s", file)
+		s.readRest(file, head, 0, p)
+
+	default:
+		log.Infof("Not forwarding %s, which was compressed before it was found", file)
+		s.offsets.MarkSeen(fp, 0, true)
+	}
+}
+
+// readRest sends a file from offset to the end, then marks its contents as
+// sent. Compressed files are complete once read; plain ones may grow.
+func (s *Server) readRest(file string, head []byte, offset int64, p *pipeline) {
+	r, err := openLog(file)
+	if err != nil {
+		log.Errorf("%s", err)
+		return
+	}
+	defer r.Close()
+
+	if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+		log.Errorf("Failed to skip to offset %d of %s: %s", offset, file, err)
+		return
+	}
+
+	complete := isCompressed(file)
+
+	br := bufio.NewReader(r)
+	for {
+		l, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			log.Errorf("Failed to read %s: %s", file, err)
+			complete = false
+			break
+		}
+
+		// a partial last line is left for whoever appends to it, unless
+		// the file is compressed and so can't be appended to
+		if err == io.EOF && (l == "" || !isCompressed(file)) {
+			break
+		}
+
+		if s.closing() {
+			complete = false
+			break
+		}
+
+		offset += int64(len(l))
+		p.line(strings.TrimSuffix(l, "\n"), time.Time{})
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	s.offsets.MarkSeen(newFingerprint(head), offset, complete)
+}
+
+// readRotated sends what was written to a file, which has been rotated
+// away, after offset. It returns the offset it read up to.
+func (s *Server) readRotated(f *os.File, offset int64, p *pipeline) int64 {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Errorf("Failed to read the rest of %s: %s", p.file, err)
+		return offset
+	}
+
+	br := bufio.NewReader(f)
+	for {
+		l, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			log.Errorf("Failed to read the rest of %s: %s", p.file, err)
+			break
+		}
+		if l == "" || s.closing() {
+			break
+		}
+
+		// we are done with the file, so a partial line is sent too
+		p.send(strings.TrimSuffix(l, "\n"), offset)
+		offset += int64(len(l))
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return offset
+}
+
+// waitForFile waits for a rotated file to be replaced, returning false if
+// it doesn't reappear or we are stopped
+func (s *Server) waitForFile(file string) bool {
+	deadline := time.After(reappearTimeout)
+	for {
+		if _, err := os.Stat(file); err == nil {
+			return true
+		}
+
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			return false
+		case <-s.stopChan:
+			return false
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/utils"
+)
+
+// sdNotify tells systemd about the state of the server, when it's run
+// with Type=notify
+func sdNotify(states ...string) {
+	if err := utils.SdNotify(states...); err != nil {
+		log.Warningf("Failed to notify systemd: %s", err)
+	}
+}
+
+// notifyStatus sends systemd the number of files being forwarded and the
+// state of the connection, for systemctl status. It is sent along with
+// READY=1 after the first pass over the globs, which follows the initial
+// dial, and afterwards only when it changes.
+func (s *Server) notifyStatus(firstPass bool) {
+	status := "STATUS=" + s.status()
+
+	if firstPass {
+		sdNotify("READY=1", status)
+	} else if status != s.sdStatus {
+		sdNotify(status)
+	}
+	s.sdStatus = status
+}
+
+// status describes what the server is doing in a line
+func (s *Server) status() string {
+	files := len(s.registry.List())
+
+	if s.dryRun != nil {
+		return fmt.Sprintf("Dry run: printing lines from %d files", files)
+	}
+
+	dest := s.destination
+	if since := s.logger.DisconnectedSince(); !since.IsZero() {
+		return fmt.Sprintf("Forwarding %d files to %s, disconnected since %s", files, dest, since.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("Forwarding %d files to %s, connected", files, dest)
+}
+
+// watchdog pings systemd's watchdog every interval while the logger's
+// write loop is making progress: it has nothing to send, or has tried to
+// send or reconnect since the last ping. If it's stuck, systemd restarts
+// us once WatchdogSec passes without a ping.
+func (s *Server) watchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var attempts uint64
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.closed:
+			return
+		}
+
+		if s.logger != nil {
+			last := attempts
+			attempts = s.logger.Attempts()
+
+			if s.logger.Pending() > 0 && attempts == last {
+				log.Warningf("No packets sent for %s, not pinging the watchdog", interval)
+				continue
+			}
+		}
+
+		sdNotify("WATCHDOG=1")
+	}
+}
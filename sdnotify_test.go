@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSdNotify(t *testing.T) {
+	assert := assert.New(t)
+
+	socket := tmpdir + "/notify.sock"
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(socket)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	os.Setenv("WATCHDOG_USEC", "200000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	// receive waits for a datagram with the state, skipping watchdog pings
+	// unless that's what is wanted
+	receive := func(state string) (string, bool) {
+		buf := make([]byte, 1024)
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return "", false
+			}
+
+			msg := string(buf[:n])
+			if strings.Contains(msg, state) {
+				return msg, true
+			}
+		}
+	}
+
+	file := createFile(t, tmpdir+"/notify.txt")
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	config := testConfig()
+	config.Files = []LogFile{{Path: file.Name()}}
+
+	s := NewServer(config)
+	go s.Start()
+
+	msg, ok := receive("READY=1")
+	if assert.True(ok) {
+		assert.Contains(msg, "STATUS=Forwarding 1 files to ")
+		assert.Contains(msg, ", connected")
+	}
+
+	_, ok = receive("WATCHDOG=1")
+	assert.True(ok)
+
+	assert.NoError(s.Reload(config))
+	_, ok = receive("RELOADING=1")
+	assert.True(ok)
+	_, ok = receive("READY=1")
+	assert.True(ok)
+
+	s.Close()
+	_, ok = receive("STOPPING=1")
+	assert.True(ok)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/howbazaar/loggo"
+	"github.com/papertrail/remote_syslog2/utils"
+)
+
+// what secrets are replaced with in logs
+const secretMask = "****"
+
+// secrets holds every secret that has been read, so they can be masked
+// wherever they might be written out. Secrets replaced on reload are
+// kept, as they may still be in messages being logged.
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// addSecret records a secret to be masked
+func addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	for _, s := range secrets.values {
+		if s == secret {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, secret)
+}
+
+// maskSecrets replaces every secret in s
+func maskSecrets(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+
+	for _, secret := range secrets.values {
+		s = strings.Replace(s, secret, secretMask, -1)
+	}
+	return s
+}
+
+// resolveSecret returns the value of a setting that holds a secret. A
+// value starting with file: is replaced by the contents of the file named
+// after it, and ${VAR} references by the environment variable VAR, so the
+// secret itself doesn't have to be in the config file or on the command
+// line. The result is masked in logs.
+func resolveSecret(value string) (string, error) {
+	if strings.HasPrefix(value, "file:") {
+		return readSecretFile(strings.TrimPrefix(value, "file:"))
+	}
+
+	var missing []string
+	value = envRef.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("Environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	addSecret(value)
+	return value, nil
+}
+
+// readSecretFile reads a secret from a file, ignoring surrounding
+// whitespace like a trailing newline
+func readSecretFile(file string) (string, error) {
+	data, err := ioutil.ReadFile(utils.ResolvePath(file))
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", file)
+	}
+
+	addSecret(secret)
+	return secret, nil
+}
+
+// A maskingWriter masks secrets in log messages before passing them on
+type maskingWriter struct {
+	loggo.Writer
+}
+
+func (w maskingWriter) Write(level loggo.Level, module, filename string, line int, timestamp time.Time, message string) {
+	w.Writer.Write(level, module, filename, line, timestamp, maskSecrets(message))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/howbazaar/loggo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpdir + "/secret-token"
+	if err := ioutil.WriteFile(file, []byte("  from-a-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	os.Setenv("RS_TEST_TOKEN", "from-the-env")
+	defer os.Unsetenv("RS_TEST_TOKEN")
+
+	secret, err := resolveSecret("file:" + file)
+	assert.NoError(err)
+	assert.Equal("from-a-file", secret)
+
+	secret, err = resolveSecret("${RS_TEST_TOKEN}")
+	assert.NoError(err)
+	assert.Equal("from-the-env", secret)
+
+	secret, err = resolveSecret("plain-token")
+	assert.NoError(err)
+	assert.Equal("plain-token", secret)
+
+	_, err = resolveSecret("${RS_TEST_MISSING}")
+	assert.EqualError(err, "Environment variable RS_TEST_MISSING is not set")
+
+	_, err = resolveSecret("file:" + tmpdir + "/no-such-token")
+	assert.Error(err)
+
+	empty := tmpdir + "/empty-token"
+	ioutil.WriteFile(empty, []byte("\n"), 0600)
+	defer os.Remove(empty)
+
+	_, err = readSecretFile(empty)
+	assert.EqualError(err, empty+" is empty")
+
+	// every secret that was read is masked
+	assert.Equal("token ****, **** and ****", maskSecrets("token from-a-file, from-the-env and plain-token"))
+}
+
+func TestResolveToken(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpdir + "/token-file"
+	if err := ioutil.WriteFile(file, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	c := &Config{}
+	c.Destination.TokenFile = file
+	assert.Empty(c.resolveToken())
+	assert.Equal("file-token", c.Destination.Token)
+
+	// both can't be set
+	c = &Config{}
+	c.Destination.Token = "config-token"
+	c.Destination.TokenFile = file
+	problems := c.resolveToken()
+	if assert.Len(problems, 1) {
+		assert.Equal("destination.token_file", problems[0].Key)
+	}
+
+	c = &Config{}
+	c.Destination.Token = "${RS_TEST_MISSING}"
+	problems = c.resolveToken()
+	if assert.Len(problems, 1) {
+		assert.Equal("destination.token", problems[0].Key)
+	}
+}
+
+func TestMaskingWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	addSecret("masked-in-logs")
+
+	w := &loggo.TestWriter{}
+	maskingWriter{w}.Write(loggo.INFO, "test", "secrets.go", 1, time.Now(), "Sending with masked-in-logs")
+
+	if assert.Len(w.Log, 1) {
+		assert.Equal("Sending with ****", w.Log[0].Message)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/howbazaar/loggo"
+	"github.com/papertrail/remote_syslog2/utils"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// sendSummary counts what Send did with the lines it read
+type sendSummary struct {
+	files, failed                          int
+	lines, forwarded, filtered, outOfRange int
+	repeated, limited, abandoned           int
+}
+
+func (s sendSummary) String() string {
+	return fmt.Sprintf("Sent %d of %d lines from %d files (%d failed): "+
+		"%d filtered, %d outside the time range, %d repeated, %d rate limited, %d abandoned",
+		s.forwarded, s.lines, s.files, s.failed,
+		s.filtered, s.outOfRange, s.repeated, s.limited, s.abandoned)
+}
+
+// Send forwards each file from the beginning through the usual filters,
+// then waits for the queue to drain, so logs a destination missed can be
+// sent again. Lines are sent with the time they were logged, when it can
+// be parsed, and only lines logged between Since and Until are sent; a
+// line without a timestamp takes the time of the line before it. An error
+// is returned if a file couldn't be read, sending was interrupted or
+// packets were abandoned.
+func (s *Server) Send(files []string) (summary sendSummary, err error) {
+	if err := s.config.Validate(); err != nil {
+		return summary, err
+	}
+
+	loggo.ConfigureLoggers(s.config.LogLevels)
+
+	// a backfill isn't tailing, so must not replace the saved offsets
+	s.config.StateFile = ""
+
+	s.limiter = newRateLimiter(s.config.RateLimit)
+	if err := s.dial(); err != nil {
+		return summary, err
+	}
+
+	s.mu.RLock()
+	logger := s.logger
+	s.mu.RUnlock()
+
+	switch {
+	case logger != nil:
+		go func() {
+			for err := range logger.Errors {
+				logEvent(loggo.ERROR, eventDestinationError, s.destinationFields(err), "Syslog error: %v", err)
+			}
+		}()
+	case s.dryRun == nil:
+		// interrupted while connecting
+		s.Close()
+		return summary, errInterrupted
+	}
+
+	// Close waits for tailers, so an interrupt lets the current line finish
+	s.tailers.Add(1)
+	for _, file := range files {
+		summary.files++
+
+		if err = s.sendFile(file, &summary); err == errInterrupted {
+			summary.failed++
+			break
+		}
+		if err != nil {
+			summary.failed++
+			log.Errorf("Failed to send %s: %s", file, err)
+		}
+	}
+	s.tailers.Done()
+
+	s.Close()
+	summary.abandoned = s.abandoned
+
+	switch {
+	case err == errInterrupted:
+		return summary, err
+	case summary.failed > 0:
+		return summary, fmt.Errorf("%d of %d files failed", summary.failed, summary.files)
+	case summary.abandoned > 0:
+		return summary, fmt.Errorf("%d packets were abandoned", summary.abandoned)
+	}
+
+	return summary, nil
+}
+
+func (s *Server) sendFile(file string, summary *sendSummary) error {
+	r, err := openLog(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	log.Infof("Sending file: %s", file)
+
+	p := s.newPipeline(file, s.logFileFor(file))
+	defer func() {
+		p.end()
+
+		summary.forwarded += p.forwarded
+		summary.filtered += p.filtered
+		summary.repeated += p.repeated
+		summary.limited += p.limited
+	}()
+
+	var (
+		since, until = s.config.Since, s.config.Until
+		ranged       = !since.IsZero() || !until.IsZero()
+		now          = time.Now()
+		at           time.Time
+	)
+
+	br := bufio.NewReader(r)
+	for {
+		l, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if l == "" && err == io.EOF {
+			return nil
+		}
+
+		if s.closing() {
+			return errInterrupted
+		}
+
+		l = strings.TrimSuffix(l, "\n")
+		summary.lines++
+
+		if t, ok := parseTimestamp(l, now); ok {
+			at = t
+		}
+
+		if ranged && (at.IsZero() || at.Before(since) || (!until.IsZero() && !at.Before(until))) {
+			summary.outOfRange++
+			log.Tracef("Outside the time range: %s", l)
+			continue
+		}
+
+		p.line(l, at)
+	}
+}
+
+// logFileFor returns the settings for a file named on the command line:
+// those of the first configured file whose glob matches it, if any
+func (s *Server) logFileFor(file string) LogFile {
+	abs, _ := filepath.Abs(file)
+
+	for _, glob := range s.config.Files {
+		pattern := utils.ResolvePath(glob.Path)
+
+		for _, name := range []string{file, abs} {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				lf := glob.forFile(name)
+				lf.Path = file
+				return lf
+			}
+		}
+	}
+
+	return LogFile{Path: file}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"compress/gzip"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/syslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSend(t *testing.T) {
+	assert := assert.New(t)
+
+	file := tmpLogFile()
+	defer file.Close()
+
+	for _, l := range []string{
+		"2024-01-08T23:00:00Z send too early",
+		"2024-01-09T08:00:00Z send first",
+		"send continued",
+		"2024-01-09T09:00:00Z send don't log on me",
+		"2024-01-10T00:00:00Z send too late",
+	} {
+		writeLog(file, l)
+	}
+
+	gzName := tmpdir + "/rotated.log.1.gz"
+	gzFile, err := os.Create(gzName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(gzName)
+
+	gz := gzip.NewWriter(gzFile)
+	gz.Write([]byte("2024-01-09T10:00:00Z send from gzip\n"))
+	gz.Close()
+	gzFile.Close()
+
+	config := testConfig()
+	config.ExcludePatterns = append(config.ExcludePatterns, regexp.MustCompile("don't log on me"))
+	config.Since = time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)
+	config.Until = time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	// Send waits for the packets to be written, so receive them meanwhile
+	received := make(chan []syslog.Packet)
+	go func() {
+		var packets []syslog.Packet
+		timeout := time.After(5 * time.Second)
+		for len(packets) < 3 {
+			select {
+			case packet := <-server.packets:
+				if strings.Contains(packet.Message, "send ") {
+					packets = append(packets, packet)
+				}
+			case <-timeout:
+				received <- packets
+				return
+			}
+		}
+		received <- packets
+	}()
+
+	s := NewServer(config)
+	summary, err := s.Send([]string{file.Name(), gzName, tmpdir + "/missing.log"})
+	assert.Error(err, "Expected the missing file to fail")
+
+	assert.Equal(3, summary.files)
+	assert.Equal(1, summary.failed)
+	assert.Equal(6, summary.lines)
+	assert.Equal(3, summary.forwarded)
+	assert.Equal(1, summary.filtered)
+	assert.Equal(2, summary.outOfRange)
+	assert.Equal(0, summary.abandoned)
+
+	packets := <-received
+	if assert.Len(packets, 3) {
+		assert.True(strings.HasSuffix(packets[0].Message, "send first"))
+		assert.Equal("send continued", packets[1].Message)
+		assert.True(strings.HasSuffix(packets[2].Message, "send from gzip"))
+
+		// lines are sent with the time they were logged
+		first := time.Date(2024, 1, 9, 8, 0, 0, 0, time.UTC)
+		assert.True(first.Equal(packets[0].Time))
+		assert.True(first.Equal(packets[1].Time))
+		assert.Equal("rotated.log.1.gz", packets[2].Tag)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Ways a file can be started from
+const (
+	StartEnd       = "end"
+	StartBeginning = "beginning"
+	StartSaved     = "saved"
+	StartLastLines = "lines"
+	StartLastBytes = "bytes"
+)
+
+// A StartPosition says where in a file forwarding starts when it is first
+// tailed. The zero value keeps the historical behaviour: files found at
+// startup are read from the end, files found later from the beginning.
+type StartPosition struct {
+	Mode string
+
+	// Count is the number of lines or bytes for the "last N" modes
+	Count int64
+}
+
+func (p StartPosition) String() string {
+	switch p.Mode {
+	case StartLastLines, StartLastBytes:
+		return fmt.Sprintf("last %d %s", p.Count, p.Mode)
+	case "":
+		return "default"
+	default:
+		return p.Mode
+	}
+}
+
+// ParseStartPosition parses "end", "beginning", "saved", "last N lines"
+// or "last N bytes"
+func ParseStartPosition(s string) (StartPosition, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		return StartPosition{}, nil
+	case StartEnd, StartBeginning, StartSaved:
+		return StartPosition{Mode: s}, nil
+	}
+
+	f := strings.Fields(s)
+	if len(f) == 3 && f[0] == "last" {
+		n, err := strconv.ParseInt(f[1], 10, 64)
+		if err == nil && n >= 0 && (f[2] == StartLastLines || f[2] == StartLastBytes) {
+			return StartPosition{Mode: f[2], Count: n}, nil
+		}
+	}
+
+	return StartPosition{}, fmt.Errorf("Invalid start position %q, try end, beginning, saved, \"last N lines\" or \"last N bytes\"", s)
+}
+
+func decodeStartPosition(f interface{}) (StartPosition, error) {
+	switch val := f.(type) {
+	case string:
+		return ParseStartPosition(val)
+
+	// already decoded
+	case StartPosition:
+		return val, nil
+
+	default:
+		return StartPosition{}, fmt.Errorf("Invalid input type for start position: %#v", f)
+	}
+}
+
+// offset returns where to start reading file. saved is the offset recorded
+// for the file by a previous run, if any; without one, or if the file is
+// now shorter than it, "saved" falls back to the end or the beginning.
+func (p StartPosition) offset(file string, saved int64, hasSaved bool) (int64, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+
+	switch p.Mode {
+	case StartBeginning:
+		return 0, nil
+
+	case StartSaved:
+		switch {
+		case !hasSaved:
+			log.Infof("No saved offset for %s, starting at the end", file)
+			return size, nil
+		case saved > size:
+			log.Infof("%s is shorter than its saved offset %d, starting at the beginning", file, saved)
+			return 0, nil
+		default:
+			return saved, nil
+		}
+
+	case StartLastBytes:
+		if p.Count >= size {
+			return 0, nil
+		}
+		return size - p.Count, nil
+
+	case StartLastLines:
+		return lastLinesOffset(file, size, p.Count)
+
+	default:
+		return size, nil
+	}
+}
+
+// lastLinesOffset returns the offset of the start of the last n lines of
+// a file of the given size. A final line without a newline counts as one.
+func lastLinesOffset(file string, size, n int64) (int64, error) {
+	if n <= 0 {
+		return size, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	end := size
+
+	// the newline ending the last line doesn't start another one
+	if end > 0 {
+		if _, err := f.ReadAt(buf[:1], end-1); err != nil {
+			return 0, err
+		}
+		if buf[0] == '\n' {
+			end--
+		}
+	}
+
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i := len(chunk); i > 0; {
+			i = bytes.LastIndexByte(chunk[:i], '\n')
+			if i < 0 {
+				break
+			}
+
+			if n--; n == 0 {
+				return start + int64(i) + 1, nil
+			}
+		}
+
+		end = start
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStartPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	for s, want := range map[string]StartPosition{
+		"":                {},
+		"end":             {Mode: StartEnd},
+		"Beginning":       {Mode: StartBeginning},
+		"saved":           {Mode: StartSaved},
+		"last 10 lines":   {Mode: StartLastLines, Count: 10},
+		"last 4096 bytes": {Mode: StartLastBytes, Count: 4096},
+	} {
+		p, err := ParseStartPosition(s)
+		assert.NoError(err, s)
+		assert.Equal(want, p, s)
+	}
+
+	for _, s := range []string{"middle", "last lines", "last -1 lines", "last 10 words", "first 10 lines"} {
+		_, err := ParseStartPosition(s)
+		assert.Error(err, s)
+	}
+}
+
+func TestLastLinesOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ioutil.TempFile("", "startpos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	for _, tc := range []struct {
+		content string
+		n       int64
+		want    int64
+	}{
+		{"", 3, 0},
+		{"one\ntwo\nthree\n", 0, 14},
+		{"one\ntwo\nthree\n", 1, 8},
+		{"one\ntwo\nthree\n", 2, 4},
+		{"one\ntwo\nthree\n", 3, 0},
+		{"one\ntwo\nthree\n", 10, 0},
+		{"one\ntwo\npartial", 1, 8},
+		{"one\n\n\n", 2, 4},
+	} {
+		f.Truncate(0)
+		f.WriteAt([]byte(tc.content), 0)
+
+		offset, err := lastLinesOffset(f.Name(), int64(len(tc.content)), tc.n)
+		assert.NoError(err)
+		assert.Equal(tc.want, offset, "last %d lines of %q", tc.n, tc.content)
+	}
+}
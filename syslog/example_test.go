@@ -0,0 +1,33 @@
+package syslog_test
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/syslog"
+)
+
+func ExampleNewWriter() {
+	logger, err := syslog.DialContext(context.Background(), syslog.Options{
+		Network:        "tls",
+		Address:        "logs.example.com:514",
+		ClientHostname: "web1",
+		ConnectTimeout: 30 * time.Second,
+		WriteTimeout:   30 * time.Second,
+	})
+	if err != nil {
+		if logger == nil {
+			log.Fatalf("Failed to connect: %v", err)
+		}
+		// the logger keeps trying to connect in the background
+		log.Printf("Failed to connect: %v", err)
+	}
+	defer logger.Close()
+
+	w := syslog.NewWriter(logger, syslog.LogLocal0, syslog.SevInfo, "billing")
+	defer w.Close()
+
+	l := log.New(w, "", 0)
+	l.Println("Invoice 42 sent")
+}
@@ -0,0 +1,191 @@
+//go:build go1.21
+// +build go1.21
+
+package syslog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// HandlerOptions are options for a Handler
+type HandlerOptions struct {
+	// Level is the lowest level sent, slog.LevelInfo if nil
+	Level slog.Leveler
+
+	// AddSource adds the file and line of the call that logged a record
+	// as the "source" field
+	AddSource bool
+
+	// Hostname is sent in place of the Logger's ClientHostname if set
+	Hostname string
+
+	// Token is the ingestion token sent with each packet, if any
+	Token string
+}
+
+// A Handler is a slog.Handler that sends each record as a packet through
+// a Logger. Levels map to severities; see HandlerSeverity. Attributes are
+// sent as the packet's Fields, with those in groups named like
+// "group.key". Attributes whose names aren't valid field names, as
+// reported by ValidFieldName, are dropped.
+type Handler struct {
+	logger   *Logger
+	facility Priority
+	tag      string
+	opts     HandlerOptions
+
+	// fields from WithAttrs, and the prefix of the groups from WithGroup
+	fields map[string]string
+	prefix string
+}
+
+// NewHandler returns a Handler sending records through logger with the
+// facility and tag. opts may be nil.
+func NewHandler(logger *Logger, facility Priority, tag string, opts *HandlerOptions) *Handler {
+	h := &Handler{
+		logger:   logger,
+		facility: facility,
+		tag:      tag,
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// HandlerSeverity returns the severity a Handler sends records of the level
+// with. Levels between those slog names take the severity of the one
+// below, except that LevelInfo+2 and up are sent as notices.
+func HandlerSeverity(level slog.Level) Priority {
+	switch {
+	case level >= slog.LevelError:
+		return SevErr
+	case level >= slog.LevelWarn:
+		return SevWarning
+	case level >= slog.LevelInfo+2:
+		return SevNotice
+	case level >= slog.LevelInfo:
+		return SevInfo
+	default:
+		return SevDebug
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	min := slog.LevelInfo
+	if h.opts.Level != nil {
+		min = h.opts.Level.Level()
+	}
+	return level >= min
+}
+
+// Handle sends the record. Like Logger.Write, it waits for room when the
+// Logger's queue is full. It returns ErrClosed once the Logger is draining
+// or closed.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.logger.accepting() {
+		return ErrClosed
+	}
+
+	fields := make(map[string]string, len(h.fields)+r.NumAttrs()+1)
+	for name, value := range h.fields {
+		fields[name] = value
+	}
+
+	if h.opts.AddSource && r.PC != 0 {
+		frames := runtime.CallersFrames([]uintptr{r.PC})
+		frame, _ := frames.Next()
+		addField(fields, "", slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", frame.File, frame.Line)))
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		addField(fields, h.prefix, a)
+		return true
+	})
+
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	hostname := h.opts.Hostname
+	if hostname == "" {
+		hostname = h.logger.ClientHostname
+	}
+
+	packet := Packet{
+		Severity: HandlerSeverity(r.Level),
+		Facility: h.facility,
+		Time:     t,
+		Hostname: hostname,
+		Tag:      h.tag,
+		Token:    h.opts.Token,
+		Message:  r.Message,
+	}
+	if len(fields) > 0 {
+		packet.Fields = fields
+	}
+
+	h.logger.Write(packet)
+	return nil
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	h2 := *h
+	h2.fields = make(map[string]string, len(h.fields)+len(attrs))
+	for name, value := range h.fields {
+		h2.fields[name] = value
+	}
+	for _, a := range attrs {
+		addField(h2.fields, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+// addField adds the attribute to fields, named with prefix, flattening
+// groups into a field per attribute
+func addField(fields map[string]string, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		// a group without a key is inlined
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addField(fields, prefix, ga)
+		}
+	case slog.KindTime:
+		addValue(fields, prefix+a.Key, a.Value.Time().Format(time.RFC3339Nano))
+	default:
+		addValue(fields, prefix+a.Key, a.Value.String())
+	}
+}
+
+func addValue(fields map[string]string, name, value string) {
+	if ValidFieldName(name) {
+		fields[name] = value
+	}
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+// +build go1.21
+
+package syslog_test
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/papertrail/remote_syslog2/syslog"
+)
+
+func ExampleNewHandler() {
+	logger, err := syslog.DialContext(context.Background(), syslog.Options{
+		Network:        "tls",
+		Address:        "logs.example.com:514",
+		ClientHostname: "web1",
+		ConnectTimeout: 30 * time.Second,
+		WriteTimeout:   30 * time.Second,
+	})
+	if err != nil {
+		if logger == nil {
+			log.Fatalf("Failed to connect: %v", err)
+		}
+		// the logger keeps trying to connect in the background
+		log.Printf("Failed to connect: %v", err)
+	}
+	defer logger.Close()
+
+	l := slog.New(syslog.NewHandler(logger, syslog.LogLocal0, "billing", &syslog.HandlerOptions{Level: slog.LevelDebug}))
+
+	// sent with the fields invoice="42" and customer.id="7"
+	l.Info("Invoice sent", "invoice", 42, slog.Group("customer", "id", 7))
+}
@@ -0,0 +1,59 @@
+//go:build go1.21
+// +build go1.21
+
+package syslog
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := newQueueLogger()
+	h := NewHandler(logger, LogLocal0, "app", &HandlerOptions{Level: slog.LevelDebug, Token: "token"})
+	log := slog.New(h).With("request", 42).WithGroup("http")
+
+	log.Debug("ignored by level", "n", 1)
+	log.Info("handled", "status", 200, slog.Group("req", "method", "GET"), "bad name", "dropped")
+	log.Log(context.Background(), slog.LevelInfo+2, "notice")
+	log.Warn("warn")
+	log.Error("error", slog.Group("", "inline", true))
+
+	packets := queued(logger)
+	if !assert.Len(packets, 5) {
+		return
+	}
+
+	assert.Equal(SevDebug, packets[0].Severity)
+
+	p := packets[1]
+	assert.Equal(SevInfo, p.Severity)
+	assert.Equal(LogLocal0, p.Facility)
+	assert.Equal("app", p.Tag)
+	assert.Equal("token", p.Token)
+	assert.Equal(clienthost, p.Hostname)
+	assert.Equal("handled", p.Message)
+	assert.WithinDuration(time.Now(), p.Time, time.Second)
+	assert.Equal(map[string]string{
+		"request":         "42",
+		"http.status":     "200",
+		"http.req.method": "GET",
+	}, p.Fields)
+
+	assert.Equal(SevNotice, packets[2].Severity)
+	assert.Equal(SevWarning, packets[3].Severity)
+	assert.Equal(SevErr, packets[4].Severity)
+	assert.Equal("true", packets[4].Fields["http.inline"])
+
+	// records below LevelInfo aren't sent by default
+	assert.False(NewHandler(logger, LogLocal0, "app", nil).Enabled(context.Background(), slog.LevelDebug))
+
+	logger.Close()
+	assert.Equal(ErrClosed, h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "closed", 0)))
+}
@@ -0,0 +1,148 @@
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Framing is how packets are delimited on tcp and tls connections
+type Framing int
+
+const (
+	// FramingNewline ends each packet with a newline
+	FramingNewline Framing = iota
+
+	// FramingOctetCounting starts each packet with its length in bytes and
+	// a space, as RFC 6587 describes
+	FramingOctetCounting
+)
+
+// Defaults for Options left zero
+const (
+	DefaultQueueSize     = 100
+	DefaultRetryInterval = 10 * time.Second
+)
+
+// Options configure a Logger. Only Network and Address are required.
+type Options struct {
+	// Network is udp, tcp or tls, and Address the server's host:port
+	Network string
+	Address string
+
+	// ClientHostname sets the Logger's ClientHostname
+	ClientHostname string
+
+	// TLSConfig configures tls connections. Without one, the server's
+	// certificate is checked against RootCAs, or the system's roots if
+	// that's nil too. The server name is taken from Address unless set.
+	TLSConfig *tls.Config
+	RootCAs   *x509.CertPool
+
+	// ConnectTimeout limits each attempt at connecting, and WriteTimeout
+	// each attempt at writing a packet. Zero means no limit.
+	ConnectTimeout time.Duration
+	WriteTimeout   time.Duration
+
+	// Framing delimits packets on tcp and tls connections, where packets
+	// longer than MaxLength bytes are truncated unless it's zero. udp
+	// packets are always truncated to 1024 bytes.
+	Framing   Framing
+	MaxLength int
+
+	// QueueSize is how many packets Write queues before waiting for room,
+	// DefaultQueueSize if zero
+	QueueSize int
+
+	// RetryInterval is how long to wait after failing to connect or to
+	// write a packet before trying again, DefaultRetryInterval if zero. If
+	// MaxRetryInterval is longer, the wait doubles after each failure in a
+	// row, up to MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// DialFunc makes connections in place of a net.Dialer if set, to go
+	// through a proxy for example. It's called with tcp for tls
+	// connections, and TLS is layered over the connection it returns.
+	DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// withDefaults returns the options with defaults in place of zero values,
+// or an error if they can't work
+func (o Options) withDefaults() (Options, error) {
+	switch o.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return o, fmt.Errorf("Network protocol %s not supported", o.Network)
+	}
+
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultQueueSize
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = DefaultRetryInterval
+	}
+	return o, nil
+}
+
+// retryWait returns how long to wait before trying again after that many
+// failures in a row
+func (o *Options) retryWait(failures int) time.Duration {
+	wait := o.RetryInterval
+	for i := 1; i < failures && wait < o.MaxRetryInterval; i++ {
+		wait *= 2
+	}
+	if o.MaxRetryInterval > o.RetryInterval && wait > o.MaxRetryInterval {
+		wait = o.MaxRetryInterval
+	}
+	return wait
+}
+
+// dialNet makes a connection to Address, through DialFunc if set
+func (o *Options) dialNet(ctx context.Context, network string) (net.Conn, error) {
+	if o.DialFunc != nil {
+		return o.DialFunc(ctx, network, o.Address)
+	}
+
+	dialer := &net.Dialer{}
+	if o.Network == "tls" {
+		dialer.KeepAlive = time.Second * 60 * 3 // 3 minutes
+	}
+	return dialer.DialContext(ctx, network, o.Address)
+}
+
+// tlsConfig returns the configuration for tls connections
+func (o *Options) tlsConfig() *tls.Config {
+	var config *tls.Config
+	if o.TLSConfig != nil {
+		config = o.TLSConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+
+	if config.RootCAs == nil {
+		config.RootCAs = o.RootCAs
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(o.Address); err == nil {
+			config.ServerName = host
+		}
+	}
+	return config
+}
+
+// frame returns the packet as it's written to the connection
+func (o *Options) frame(p Packet, udp bool) string {
+	if udp {
+		return p.Generate(1024)
+	}
+
+	msg := p.Generate(o.MaxLength)
+	if o.Framing == FramingOctetCounting {
+		return fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	return msg + "\n"
+}
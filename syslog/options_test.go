@@ -0,0 +1,120 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryWait(t *testing.T) {
+	assert := assert.New(t)
+
+	o, _ := Options{Network: "tcp"}.withDefaults()
+	assert.Equal(DefaultRetryInterval, o.retryWait(1))
+	assert.Equal(DefaultRetryInterval, o.retryWait(5))
+
+	// doubling up to the maximum
+	o = Options{RetryInterval: time.Second, MaxRetryInterval: 5 * time.Second}
+	assert.Equal(time.Second, o.retryWait(1))
+	assert.Equal(2*time.Second, o.retryWait(2))
+	assert.Equal(4*time.Second, o.retryWait(3))
+	assert.Equal(5*time.Second, o.retryWait(4))
+	assert.Equal(5*time.Second, o.retryWait(10))
+}
+
+func TestDialContext(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var length int
+		r := bufio.NewReader(conn)
+		if _, err := fmt.Fscanf(r, "%d ", &length); err != nil {
+			return
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(r, msg); err == nil {
+			received <- string(msg)
+		}
+	}()
+
+	dialed := 0
+	logger, err := DialContext(context.Background(), Options{
+		Network:        "tcp",
+		Address:        "logs.example.com:514",
+		ClientHostname: clienthost,
+		Framing:        FramingOctetCounting,
+		QueueSize:      5,
+		DialFunc: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed++
+			assert.Equal("tcp", network)
+			assert.Equal("logs.example.com:514", address)
+			return (&net.Dialer{}).DialContext(ctx, network, ln.Addr().String())
+		},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	defer logger.Close()
+
+	assert.Equal(1, dialed)
+	assert.Equal(5, cap(logger.Packets))
+
+	packet := generatePackets()[0]
+	logger.Write(packet)
+
+	select {
+	case msg := <-received:
+		assert.Equal(packet.Generate(0), msg)
+	case <-time.After(5 * time.Second):
+		t.Error("Expected a packet")
+	}
+}
+
+func TestDialContextCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	logger, err := DialContext(ctx, Options{
+		Network: "tcp",
+		Address: "logs.example.com:514",
+		DialFunc: func(ctx context.Context, network, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	assert.Nil(logger)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	logger, err = DialContext(context.Background(), Options{Network: "smtp", Address: "logs.example.com:25"})
+	assert.Nil(logger)
+	if assert.Error(err) {
+		assert.True(strings.Contains(err.Error(), "smtp"))
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	o := Options{Network: "tls", Address: "logs.example.com:6514"}
+	assert.Equal(t, "logs.example.com", o.tlsConfig().ServerName)
+}
@@ -2,10 +2,15 @@ package syslog
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 )
 
+// FieldsSDID is the SD-ID of the structured data element carrying a
+// packet's Fields
+const FieldsSDID = "fields@41058"
+
 // A Packet represents an RFC5424 syslog message
 type Packet struct {
 	Severity Priority
@@ -15,6 +20,13 @@ type Packet struct {
 	Tag      string
 	Token    string
 	Message  string
+
+	// Fields are sent as the parameters of a FieldsSDID structured data
+	// element. Names must be valid SD-NAMEs; see ValidFieldName.
+	Fields map[string]string
+
+	// result receives the outcome of writing a packet from WriteResult
+	result chan<- error
 }
 
 // like time.RFC3339Nano but with a limit of 6 digits in the SECFRAC part
@@ -25,13 +37,56 @@ func (p Packet) Priority() Priority {
 	return (p.Facility << 3) | p.Severity
 }
 
-// Ingestion Token formatted as Loggly's SD-ID format. See RFC5424 for details.
+// Ingestion Token formatted as Loggly's SD-ID format, followed by the
+// Fields element if there are any. See RFC5424 for details.
 func (p Packet) structuredData() string {
-	if p.Token == "" {
+	if p.Token == "" && len(p.Fields) == 0 {
 		return "-"
-	} else {
-		return fmt.Sprintf("[%s@41058]", p.Token)
 	}
+
+	var b strings.Builder
+	if p.Token != "" {
+		fmt.Fprintf(&b, "[%s@41058]", p.Token)
+	}
+
+	if len(p.Fields) > 0 {
+		names := make([]string, 0, len(p.Fields))
+		for name := range p.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		b.WriteString("[" + FieldsSDID)
+		for _, name := range names {
+			fmt.Fprintf(&b, ` %s="%s"`, name, sdEscaper.Replace(p.Fields[name]))
+		}
+		b.WriteString("]")
+	}
+
+	return b.String()
+}
+
+// PARAM-VALUEs must escape '"', '\' and ']'
+var (
+	sdEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	sdUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\]`, `]`)
+)
+
+// ValidFieldName reports whether name can be used as a structured data
+// parameter name: 1 to 32 printable ASCII characters other than '=', ' ',
+// ']' and '"'.
+func ValidFieldName(name string) bool {
+	if len(name) == 0 || len(name) > 32 {
+		return false
+	}
+
+	for _, c := range []byte(name) {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (p Packet) cleanMessage() string {
@@ -60,29 +115,109 @@ func Parse(line string) (Packet, error) {
 	var (
 		packet   Packet
 		priority int
-		ts       string
-		hostname string
-		tag      string
 	)
 
-	splitLine := strings.Split(line, " - - - ")
-	if len(splitLine) != 2 {
+	// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	parts := strings.SplitN(line, " ", 7)
+	if len(parts) != 7 || parts[4] != "-" || parts[5] != "-" {
 		return packet, fmt.Errorf("couldn't parse %s", line)
 	}
 
-	fmt.Sscanf(splitLine[0], "<%d>1 %s %s %s", &priority, &ts, &hostname, &tag)
+	if _, err := fmt.Sscanf(parts[0], "<%d>1", &priority); err != nil {
+		return packet, fmt.Errorf("couldn't parse %s", line)
+	}
 
-	t, err := time.Parse(rfc5424time, ts)
+	t, err := time.Parse(rfc5424time, parts[1])
 	if err != nil {
 		return packet, err
 	}
 
-	return Packet{
+	packet = Packet{
 		Severity: Priority(priority & 7),
 		Facility: Priority(priority >> 3),
-		Hostname: hostname,
-		Tag:      tag,
+		Hostname: parts[2],
+		Tag:      parts[3],
 		Time:     t,
-		Message:  splitLine[1],
-	}, nil
+	}
+
+	rest := parts[6]
+	if strings.HasPrefix(rest, "- ") {
+		packet.Message = rest[2:]
+		return packet, nil
+	}
+
+	for strings.HasPrefix(rest, "[") {
+		end := sdElementEnd(rest)
+		if end < 0 {
+			return packet, fmt.Errorf("couldn't parse structured data in %s", line)
+		}
+
+		parseSDElement(&packet, rest[1:end])
+		rest = rest[end+1:]
+	}
+
+	if !strings.HasPrefix(rest, " ") {
+		return packet, fmt.Errorf("couldn't parse %s", line)
+	}
+	packet.Message = rest[1:]
+
+	return packet, nil
+}
+
+// sdElementEnd returns the index of the ']' closing the element at the
+// start of s, or -1
+func sdElementEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseSDElement fills in the token or fields from a structured data
+// element without its brackets
+func parseSDElement(p *Packet, element string) {
+	id := element
+	params := ""
+	if i := strings.IndexByte(element, ' '); i >= 0 {
+		id, params = element[:i], element[i+1:]
+	}
+
+	if id != FieldsSDID {
+		if params == "" && strings.HasSuffix(id, "@41058") {
+			p.Token = strings.TrimSuffix(id, "@41058")
+		}
+		return
+	}
+
+	p.Fields = make(map[string]string)
+	for params != "" {
+		eq := strings.Index(params, `="`)
+		if eq < 0 {
+			return
+		}
+		name := params[:eq]
+
+		// find the closing quote, skipping escaped characters
+		value := params[eq+2:]
+		end := -1
+		for i := 0; i < len(value); i++ {
+			if value[i] == '\\' {
+				i++
+			} else if value[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return
+		}
+
+		p.Fields[name] = sdUnescaper.Replace(value[:end])
+		params = strings.TrimPrefix(value[end+1:], " ")
+	}
 }
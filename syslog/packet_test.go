@@ -1,6 +1,7 @@
 package syslog
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -128,6 +129,35 @@ func TestPacketGenerate(t *testing.T) {
 			0,
 			"<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc - - - newline:' '. nullbyte:' '. carriage return:' '.",
 		},
+		{
+			// test fields are sorted and escaped
+			Packet{
+				Severity: SevNotice,
+				Facility: LogLocal4,
+				Time:     parseTime("2003-08-24T05:14:15.000003-07:00"),
+				Hostname: "192.0.2.1",
+				Tag:      "myproc",
+				Message:  "hello",
+				Fields:   map[string]string{"region": "us-east-1", "env": `"prod" [a\b]`},
+			},
+			0,
+			`<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc - - [fields@41058 env="\"prod\" [a\\b\]" region="us-east-1"] hello`,
+		},
+		{
+			// test ingestion token with fields
+			Packet{
+				Severity: SevNotice,
+				Facility: LogLocal4,
+				Time:     parseTime("2003-08-24T05:14:15.000003-07:00"),
+				Hostname: "192.0.2.1",
+				Tag:      "myproc",
+				Token:    "abc",
+				Message:  "hello",
+				Fields:   map[string]string{"env": "prod"},
+			},
+			0,
+			`<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc - - [abc@41058][fields@41058 env="prod"] hello`,
+		},
 	}
 	for _, test := range tests {
 		out := test.packet.Generate(test.max_size)
@@ -136,3 +166,54 @@ func TestPacketGenerate(t *testing.T) {
 		}
 	}
 }
+
+func TestPacketParseStructuredData(t *testing.T) {
+	packets := []Packet{
+		{
+			Severity: SevNotice,
+			Facility: LogLocal4,
+			Time:     parseTime("2003-08-24T05:14:15.000003-07:00"),
+			Hostname: "192.0.2.1",
+			Tag:      "myproc",
+			Token:    "abc",
+			Message:  "- [not structured data]",
+		},
+		{
+			Severity: SevNotice,
+			Facility: LogLocal4,
+			Time:     parseTime("2003-08-24T05:14:15.000003-07:00"),
+			Hostname: "192.0.2.1",
+			Tag:      "myproc",
+			Token:    "abc",
+			Message:  "hello",
+			Fields:   map[string]string{"env": `"prod" [a\b]`, "role": "web"},
+		},
+	}
+
+	for _, p := range packets {
+		parsed, err := Parse(p.Generate(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p, parsed) {
+			t.Errorf("Unexpected parse, expected\n%#v\ngot\n%#v", p, parsed)
+		}
+	}
+}
+
+func TestValidFieldName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"env":                               true,
+		"app.name":                          true,
+		"":                                  false,
+		"has space":                         false,
+		"a=b":                               false,
+		`quo"te`:                            false,
+		"brack]et":                          false,
+		"abcdefghijklmnopqrstuvwxyz0123456": false,
+	} {
+		if ValidFieldName(name) != valid {
+			t.Errorf("Expected ValidFieldName(%q) to be %v", name, valid)
+		}
+	}
+}
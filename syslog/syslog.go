@@ -7,13 +7,16 @@ reliably reconnects on failure, and supports TLS encrypted TCP connections.
 package syslog
 
 import (
+	"context"
 	_ "crypto/sha512"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -55,25 +58,32 @@ func (c *conn) Close() error {
 }
 
 // dial connects to the server and set up a watching goroutine
-func dial(network, raddr string, rootCAs *x509.CertPool, connectTimeout time.Duration) (*conn, error) {
+func dial(ctx context.Context, opts *Options) (*conn, error) {
+	if opts.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.ConnectTimeout)
+		defer cancel()
+	}
+
 	var netConn net.Conn
 	var err error
 
-	switch network {
+	switch opts.Network {
 	case "tls":
-		var config *tls.Config
-		if rootCAs != nil {
-			config = &tls.Config{RootCAs: rootCAs}
+		netConn, err = opts.dialNet(ctx, "tcp")
+		if err != nil {
+			return nil, err
 		}
-		dialer := &net.Dialer{
-			Timeout:   connectTimeout,
-			KeepAlive: time.Second * 60 * 3, // 3 minutes
+		tlsConn := tls.Client(netConn, opts.tlsConfig())
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			netConn.Close()
+			return nil, err
 		}
-		netConn, err = tls.DialWithDialer(dialer, "tcp", raddr, config)
+		netConn = tlsConn
 	case "udp", "tcp":
-		netConn, err = net.DialTimeout(network, raddr, connectTimeout)
+		netConn, err = opts.dialNet(ctx, opts.Network)
 	default:
-		return nil, fmt.Errorf("Network protocol %s not supported", network)
+		return nil, fmt.Errorf("Network protocol %s not supported", opts.Network)
 	}
 	if err != nil {
 		return nil, err
@@ -84,59 +94,268 @@ func dial(network, raddr string, rootCAs *x509.CertPool, connectTimeout time.Dur
 	}
 }
 
+// ErrClosed is returned by the Writer and Handler once their Logger no
+// longer takes packets
+var ErrClosed = errors.New("syslog: logger closed")
+
 // A Logger is a connection to a syslog server. It reconnects on error.
 // Clients log by sending a Packet to the logger.Packets channel.
 type Logger struct {
+	// conn is only replaced by writeLoop, and closed by it once the
+	// logger is closed, under mu
 	conn           *conn
 	Packets        chan Packet
 	Errors         chan error
 	ClientHostname string
 
-	network          string
-	raddr            string
-	rootCAs          *x509.CertPool
-	connectTimeout   time.Duration
-	writeTimeout     time.Duration
-	tcpMaxLineLength int
-	mu               sync.RWMutex
-	stopChan         chan struct{}
-	stopped          bool
+	opts     Options
+	mu       sync.RWMutex
+	stopChan chan struct{}
+	done     chan struct{}
+	stopped  bool
+	draining bool
+
+	// ctx is cancelled by Close, to stop reconnecting
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// failures counts failures to connect or write in a row
+	failures int
+
+	// queued counts packets accepted by Write, written those writeLoop has
+	// written and abandoned those given up on as the logger closed, so
+	// queued-written-abandoned is still in flight
+	queued    uint64
+	written   uint64
+	abandoned uint64
+
+	// attempts counts each try at connecting or writing a packet
+	attempts uint64
+
+	// disconnectedAt is when the connection was lost, as Unix nanoseconds,
+	// or 0 while it is up
+	disconnectedAt int64
 }
 
 // Dial connects to the syslog server at raddr, using the optional certBundle,
-// and launches a goroutine to watch logger.Packets for messages to log.
+// and launches a goroutine to watch logger.Packets for messages to log. It
+// is DialContext with the options it takes.
 func Dial(clientHostname, network, raddr string, rootCAs *x509.CertPool, connectTimeout time.Duration, writeTimeout time.Duration, tcpMaxLineLength int) (*Logger, error) {
+	return DialContext(context.Background(), Options{
+		Network:        network,
+		Address:        raddr,
+		ClientHostname: clientHostname,
+		RootCAs:        rootCAs,
+		ConnectTimeout: connectTimeout,
+		WriteTimeout:   writeTimeout,
+		MaxLength:      tcpMaxLineLength,
+	})
+}
+
+// DialContext connects to the syslog server described by opts and launches
+// a goroutine to write the packets passed to Write. If connecting fails,
+// the Logger is returned along with the error and keeps trying in the
+// background. If ctx is done first, only ctx's error is returned. ctx only
+// covers this first attempt.
+func DialContext(ctx context.Context, opts Options) (*Logger, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
 	// dial once, just to make sure the network is working
-	conn, err := dial(network, raddr, rootCAs, connectTimeout)
+	conn, err := dial(ctx, &opts)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, ctxErr
+	}
 
 	logger := &Logger{
-		ClientHostname:   clientHostname,
-		network:          network,
-		raddr:            raddr,
-		rootCAs:          rootCAs,
-		Packets:          make(chan Packet, 100),
-		Errors:           make(chan error, 0),
-		connectTimeout:   connectTimeout,
-		writeTimeout:     writeTimeout,
-		conn:             conn,
-		tcpMaxLineLength: tcpMaxLineLength,
-		stopChan:         make(chan struct{}, 1),
+		ClientHostname: opts.ClientHostname,
+		Packets:        make(chan Packet, opts.QueueSize),
+		Errors:         make(chan error, 0),
+		opts:           opts,
+		conn:           conn,
+		stopChan:       make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+	logger.ctx, logger.cancel = context.WithCancel(context.Background())
+	if err != nil {
+		logger.setConnected(false)
 	}
 	go logger.writeLoop()
 	return logger, err
 }
 
 func (l *Logger) Write(packet Packet) {
+	l.write(packet)
+}
+
+// WriteResult queues a packet like Write, and returns a channel that
+// receives nil once it has been written to the connection, or ErrClosed if
+// the logger is closed first. Written means handed to the operating
+// system: nothing in syslog over UDP, TCP or TLS tells us the server got it.
+func (l *Logger) WriteResult(packet Packet) <-chan error {
+	result := make(chan error, 1)
+	packet.result = result
+
+	if !l.write(packet) {
+		result <- ErrClosed
+	}
+	return result
+}
+
+// write queues a packet, reporting whether it was
+func (l *Logger) write(packet Packet) bool {
+	if !l.accepting() {
+		return false
+	}
+
+	atomic.AddUint64(&l.queued, 1)
+
+	// don't hold the lock while blocked on a full queue, or Drain and
+	// Close could never get in
+	select {
+	case l.Packets <- packet:
+		return true
+	case <-l.done:
+		atomic.AddUint64(&l.abandoned, 1)
+		return false
+	}
+}
+
+// Flush waits until the packets passed to Write before it was called have
+// been written to the connection, as WriteResult describes. It returns
+// ctx's error if ctx is done first, or ErrClosed if the logger is closed
+// before they all are.
+func (l *Logger) Flush(ctx context.Context) error {
+	target := atomic.LoadUint64(&l.queued)
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		// checked first: once closed, the packets left are abandoned, not
+		// written, whatever the counts say by now
+		select {
+		case <-l.done:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		// a packet TryWrite couldn't queue is taken back off queued
+		if queued := atomic.LoadUint64(&l.queued); queued < target {
+			target = queued
+		}
+		if atomic.LoadUint64(&l.written) >= target {
+			return nil
+		}
+
+		select {
+		case <-l.done:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// accepting reports whether Write takes packets, as it does until Drain or
+// Close is called
+func (l *Logger) accepting() bool {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	if l.stopped {
+	return !l.stopped && !l.draining
+}
+
+// TryWrite queues a packet like Write, but drops it and returns false
+// rather than wait for room when the queue is full
+func (l *Logger) TryWrite(packet Packet) bool {
+	if !l.accepting() {
+		return false
+	}
+
+	atomic.AddUint64(&l.queued, 1)
+
+	select {
+	case l.Packets <- packet:
+		return true
+	default:
+		atomic.AddUint64(&l.queued, ^uint64(0))
+		return false
+	}
+}
+
+// Drain stops accepting new packets and waits up to timeout for the
+// packets already queued to be written. It returns how many packets were
+// written while draining and how many were still queued at the deadline.
+// Only packets passed to Write are tracked. Drain does not close the
+// connection; call Close afterwards.
+func (l *Logger) Drain(timeout time.Duration) (flushed, abandoned int) {
+	l.mu.Lock()
+	l.draining = true
+	l.mu.Unlock()
+
+	start := atomic.LoadUint64(&l.written)
+	deadline := time.Now().Add(timeout)
+
+	for l.pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return int(atomic.LoadUint64(&l.written) - start), l.pending()
+}
+
+// Pending returns the number of packets queued or being written
+func (l *Logger) Pending() int {
+	return l.pending()
+}
+
+// Attempts returns how many times the logger has tried to connect or to
+// write a packet. While packets are pending, it goes up unless writing is
+// stuck.
+func (l *Logger) Attempts() uint64 {
+	return atomic.LoadUint64(&l.attempts)
+}
+
+// DisconnectedSince returns when the connection to the server was lost, or
+// the zero time if it is up. A connection is only found to be lost when
+// writing to it or reconnecting fails.
+func (l *Logger) DisconnectedSince() time.Time {
+	at := atomic.LoadInt64(&l.disconnectedAt)
+	if at == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, at)
+}
+
+// setConnected records whether the connection is up, keeping the time it
+// was first lost while reconnecting keeps failing
+func (l *Logger) setConnected(connected bool) {
+	if connected {
+		atomic.StoreInt64(&l.disconnectedAt, 0)
 		return
 	}
+	atomic.CompareAndSwapInt64(&l.disconnectedAt, 0, time.Now().UnixNano())
+}
 
-	l.Packets <- packet
+// pending returns the number of packets queued or being written
+func (l *Logger) pending() int {
+	n := int64(atomic.LoadUint64(&l.queued)) - int64(atomic.LoadUint64(&l.written)) - int64(atomic.LoadUint64(&l.abandoned))
+	if n < 0 {
+		return 0
+	}
+	return int(n)
 }
 
+// Close stops writing packets, abandoning those still queued. writeLoop
+// closes the connection once it stops.
 func (l *Logger) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -144,35 +363,98 @@ func (l *Logger) Close() error {
 	if !l.stopped {
 		l.stopped = true
 		l.stopChan <- struct{}{}
+		close(l.done)
+		if l.cancel != nil {
+			l.cancel()
+		}
 
-		err := l.conn.Close()
-		l.conn = nil
+		// a write stuck on a connection that stopped reading fails now,
+		// so writeLoop gets to stop
+		if l.conn != nil {
+			l.conn.netConn.SetWriteDeadline(time.Now())
+		}
 
 		close(l.Errors)
-
-		return err
 	}
 
 	return nil
 }
 
-// Connect to the server, retrying every 10 seconds until successful.
-func (l *Logger) connect() {
+// currentConn returns the connection packets are written to, nil until
+// the first connects
+func (l *Logger) currentConn() *conn {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.conn
+}
+
+// closeConn closes the connection, once writeLoop has stopped
+func (l *Logger) closeConn() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+func (l *Logger) closing() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.stopped
+}
+
+// Connect to the server, retrying as the options say until successful or
+// the logger is closed.
+func (l *Logger) connect() bool {
 	for {
-		c, err := dial(l.network, l.raddr, l.rootCAs, l.connectTimeout)
+		atomic.AddUint64(&l.attempts, 1)
+		c, err := dial(l.ctx, &l.opts)
 		if err == nil {
+			l.mu.Lock()
 			l.conn = c
-			return
-		} else {
-			l.handleError(err)
-			time.Sleep(10 * time.Second)
+			l.mu.Unlock()
+			l.setConnected(true)
+			l.failures = 0
+			return true
 		}
+
+		l.setConnected(false)
+		l.handleError(err)
+
+		if !l.waitToRetry() {
+			return false
+		}
+	}
+}
+
+// waitToRetry waits before trying again after a failure, returning false if
+// the logger is closed meanwhile
+func (l *Logger) waitToRetry() bool {
+	l.failures++
+
+	timer := time.NewTimer(l.opts.retryWait(l.failures))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return !l.closing()
+	case <-l.done:
+		return false
 	}
-	panic("unreachable")
 }
 
 // Send an error to the Error channel, but don't block if nothing is listening
 func (l *Logger) handleError(err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.stopped {
+		return
+	}
+
 	select {
 	case l.Errors <- err:
 	default:
@@ -180,43 +462,91 @@ func (l *Logger) handleError(err error) {
 }
 
 // Write a packet, reconnecting if needed. It is not safe to call this
-// method concurrently.
-func (l *Logger) writePacket(p Packet) {
+// method concurrently. Retries stop once the logger is closed, when
+// ErrClosed is returned.
+func (l *Logger) writePacket(p Packet) error {
 	var err error
 	for {
-		if l.conn.reconnectNeeded() {
-			l.connect()
-		}
-
-		deadline := time.Now().Add(l.writeTimeout)
-		switch l.conn.netConn.(type) {
-		case *net.TCPConn, *tls.Conn:
-			l.conn.netConn.SetWriteDeadline(deadline)
-			_, err = io.WriteString(l.conn.netConn, p.Generate(l.tcpMaxLineLength)+"\n")
-		case *net.UDPConn:
-			l.conn.netConn.SetWriteDeadline(deadline)
-			_, err = io.WriteString(l.conn.netConn, p.Generate(1024))
-		default:
-			panic(fmt.Errorf("Network protocol %s not supported", l.network))
+		c := l.currentConn()
+		if c.reconnectNeeded() {
+			if !l.connect() {
+				return ErrClosed
+			}
+			c = l.currentConn()
+		}
+
+		atomic.AddUint64(&l.attempts, 1)
+		var deadline time.Time
+		if l.opts.WriteTimeout > 0 {
+			deadline = time.Now().Add(l.opts.WriteTimeout)
 		}
+		c.netConn.SetWriteDeadline(deadline)
+
+		// checked after the deadline is set, so either Close's deadline
+		// wins or we see it has been closed
+		if l.closing() {
+			return ErrClosed
+		}
+
+		_, err = io.WriteString(c.netConn, l.opts.frame(p, l.opts.Network == "udp"))
 		if err == nil {
-			return
+			l.failures = 0
+			return nil
 		} else {
 			// We had an error -- we need to close the connection and try again
-			l.conn.netConn.Close()
+			c.netConn.Close()
+			l.setConnected(false)
 			l.handleError(err)
-			time.Sleep(10 * time.Second)
+
+			if !l.waitToRetry() {
+				return ErrClosed
+			}
+		}
+	}
+}
+
+// abandonQueued tells those waiting on the packets still queued once the
+// logger is closed that they won't be written
+func (l *Logger) abandonQueued() {
+	for {
+		select {
+		case p := <-l.Packets:
+			atomic.AddUint64(&l.abandoned, 1)
+			if p.result != nil {
+				p.result <- ErrClosed
+			}
+		default:
+			return
 		}
 	}
 }
 
 // writeloop writes any packets recieved on l.Packets() to the syslog server.
 func (l *Logger) writeLoop() {
+	defer l.closeConn()
+
 	for {
+		// once closed, nothing more is written even if packets are queued
+		select {
+		case <-l.stopChan:
+			l.abandonQueued()
+			return
+		default:
+		}
+
 		select {
 		case p := <-l.Packets:
-			l.writePacket(p)
+			err := l.writePacket(p)
+			if err != nil {
+				atomic.AddUint64(&l.abandoned, 1)
+			} else {
+				atomic.AddUint64(&l.written, 1)
+			}
+			if p.result != nil {
+				p.result <- err
+			}
 		case <-l.stopChan:
+			l.abandonQueued()
 			return
 		}
 	}
@@ -1,9 +1,12 @@
 package syslog
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -182,3 +185,212 @@ func TestSyslog(t *testing.T) {
 		}
 	}
 }
+
+func TestDrain(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 20)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	logger, err := Dial(clienthost, "tcp", ln.Addr().String(), nil, time.Second, time.Second, 99990)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, logger.DisconnectedSince().IsZero())
+
+	packets := generatePackets()
+	for _, p := range packets {
+		logger.Write(p)
+	}
+
+	flushed, abandoned := logger.Drain(5 * time.Second)
+	assert.Equal(t, 0, abandoned)
+	assert.True(t, flushed <= len(packets))
+
+	// writes after draining has started are dropped
+	logger.Write(packets[0])
+	assert.NoError(t, logger.Close())
+
+	for _, p := range packets {
+		select {
+		case got := <-received:
+			assert.Equal(t, p.Generate(0), got)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s, got nothing", p.Generate(0))
+		}
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	// nothing is listening, so every write fails and the queue can't drain
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	logger, _ := Dial(clienthost, "tcp", addr, nil, time.Second, time.Second, 99990)
+	assert.False(t, logger.DisconnectedSince().IsZero())
+
+	for _, p := range generatePackets() {
+		logger.Write(p)
+	}
+	assert.Equal(t, 10, logger.Pending())
+
+	flushed, abandoned := logger.Drain(100 * time.Millisecond)
+	assert.Equal(t, 0, flushed)
+	assert.Equal(t, 10, abandoned)
+	logger.Close()
+}
+
+func TestFlush(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 20)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	logger, err := Dial(clienthost, "tcp", ln.Addr().String(), nil, time.Second, time.Second, 99990)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	packets := generatePackets()
+	for _, p := range packets[:9] {
+		logger.Write(p)
+	}
+	result := logger.WriteResult(packets[9])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(logger.Flush(ctx))
+	assert.Equal(0, logger.Pending())
+
+	select {
+	case err := <-result:
+		assert.NoError(err)
+	default:
+		t.Error("Expected the result once flushed")
+	}
+
+	for _, p := range packets {
+		select {
+		case got := <-received:
+			assert.Equal(p.Generate(0), got)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s, got nothing", p.Generate(0))
+		}
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	// nothing is listening, so every write fails
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	logger, _ := Dial(clienthost, "tcp", addr, nil, time.Second, time.Second, 99990)
+
+	packets := generatePackets()
+	first := logger.WriteResult(packets[0])
+	queued := logger.WriteResult(packets[1])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, logger.Flush(ctx))
+
+	// the packet being retried and the one still queued are both abandoned
+	logger.Close()
+	for _, result := range []<-chan error{first, queued} {
+		select {
+		case err := <-result:
+			assert.Equal(ErrClosed, err)
+		case <-time.After(time.Second):
+			t.Error("Expected a result once closed")
+		}
+	}
+
+	assert.Equal(ErrClosed, <-logger.WriteResult(packets[2]))
+	assert.Equal(ErrClosed, logger.Flush(context.Background()))
+}
+
+func TestCloseStuckWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	// the server never reads, so writes block once the buffers are full
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	logger, err := Dial(clienthost, "tcp", ln.Addr().String(), nil, time.Second, 0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := Packet{Severity: SevInfo, Facility: LogLocal1, Hostname: clienthost, Tag: "test", Time: time.Now(), Message: strings.Repeat("x", 1<<20)}
+	var results []<-chan error
+	for i := 0; i < 20; i++ {
+		results = append(results, logger.WriteResult(packet))
+	}
+
+	_, abandoned := logger.Drain(100 * time.Millisecond)
+	assert.NotZero(abandoned)
+
+	// the stuck write fails and nothing is left pending
+	logger.Close()
+	for _, result := range results {
+		select {
+		case <-result:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a result once closed")
+		}
+	}
+	assert.Equal(0, logger.Pending())
+	assert.Equal(ErrClosed, <-logger.WriteResult(packet))
+	assert.Equal(0, logger.Pending())
+}
@@ -0,0 +1,101 @@
+package syslog
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Writer is an io.Writer that sends each line written to it as a packet
+// through a Logger, so a log.Logger or anything else that writes lines can
+// use the Logger's reconnecting connection. Empty lines aren't sent.
+type Writer struct {
+	Facility Priority
+	Severity Priority
+	Tag      string
+
+	// Hostname is sent in place of the Logger's ClientHostname if set
+	Hostname string
+
+	// Token is the ingestion token sent with each packet, if any
+	Token string
+
+	logger *Logger
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewWriter returns a Writer sending lines through logger with the
+// facility, severity and tag
+func NewWriter(logger *Logger, facility, severity Priority, tag string) *Writer {
+	return &Writer{
+		Facility: facility,
+		Severity: severity,
+		Tag:      tag,
+		logger:   logger,
+	}
+}
+
+// Write sends each complete line in p, holding on to any partial line at
+// the end until the rest of it is written. Like Logger.Write, it waits for
+// room when the Logger's queue is full. It returns ErrClosed once the
+// Logger is draining or closed.
+func (w *Writer) Write(p []byte) (int, error) {
+	if !w.logger.accepting() {
+		return 0, ErrClosed
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.send(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Close sends the partial line still held, if any. The Logger is left
+// open.
+func (w *Writer) Close() error {
+	if !w.logger.accepting() {
+		return ErrClosed
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.send(string(w.buf))
+	w.buf = nil
+	return nil
+}
+
+func (w *Writer) send(line string) {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" {
+		return
+	}
+
+	hostname := w.Hostname
+	if hostname == "" {
+		hostname = w.logger.ClientHostname
+	}
+
+	w.logger.Write(Packet{
+		Severity: w.Severity,
+		Facility: w.Facility,
+		Time:     time.Now(),
+		Hostname: hostname,
+		Tag:      w.Tag,
+		Token:    w.Token,
+		Message:  line,
+	})
+}
@@ -0,0 +1,65 @@
+package syslog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newQueueLogger returns a Logger that only queues packets, for tests to
+// read from its Packets channel
+func newQueueLogger() *Logger {
+	return &Logger{
+		ClientHostname: clienthost,
+		Packets:        make(chan Packet, 10),
+		Errors:         make(chan error),
+		stopChan:       make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+}
+
+func queued(l *Logger) []Packet {
+	var packets []Packet
+	for {
+		select {
+		case p := <-l.Packets:
+			packets = append(packets, p)
+		default:
+			return packets
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := newQueueLogger()
+	w := NewWriter(logger, LogLocal1, SevWarning, "app")
+	w.Token = "token"
+
+	fmt.Fprint(w, "first\nsec")
+	fmt.Fprint(w, "ond\r\n\n")
+	fmt.Fprint(w, "partial")
+
+	var messages []string
+	for _, p := range queued(logger) {
+		messages = append(messages, p.Message)
+		assert.Equal(LogLocal1, p.Facility)
+		assert.Equal(SevWarning, p.Severity)
+		assert.Equal("app", p.Tag)
+		assert.Equal("token", p.Token)
+		assert.Equal(clienthost, p.Hostname)
+	}
+	assert.Equal([]string{"first", "second"}, messages)
+
+	// closing sends the partial line
+	assert.NoError(w.Close())
+	if packets := queued(logger); assert.Len(packets, 1) {
+		assert.Equal("partial", packets[0].Message)
+	}
+
+	logger.Close()
+	_, err := fmt.Fprintln(w, "closed")
+	assert.Equal(ErrClosed, err)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// how far into a line to look for its timestamp, so dates in the message
+// itself aren't mistaken for it
+const timestampSearchLength = 100
+
+var (
+	// 2006-01-02T15:04:05.000Z07:00, with a space or T and optional
+	// fraction and zone
+	isoTimestamp = regexp.MustCompile(`(\d{4}-\d\d-\d\d)[T ](\d\d:\d\d:\d\d)(?:[.,](\d+))?(Z|[+-]\d\d:?\d\d)?`)
+
+	// [02/Jan/2006:15:04:05 -0700] from Apache and nginx access logs
+	clfTimestamp = regexp.MustCompile(`\[(\d\d/[A-Z][a-z]{2}/\d{4}:\d\d:\d\d:\d\d [+-]\d{4})\]`)
+
+	// Jan _2 15:04:05 from traditional syslog, which has no year
+	syslogTimestamp = regexp.MustCompile(`^(?:<\d+>)?([A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d)`)
+)
+
+// parseTimestamp finds the timestamp near the start of a log line. Times
+// without a zone are taken to be local, and syslog times without a year
+// are given the year that puts them closest before now.
+func parseTimestamp(line string, now time.Time) (time.Time, bool) {
+	if len(line) > timestampSearchLength {
+		line = line[:timestampSearchLength]
+	}
+
+	if m := isoTimestamp.FindStringSubmatch(line); m != nil {
+		s := m[1] + "T" + m[2]
+		if m[3] != "" {
+			s += "." + m[3]
+		}
+
+		var (
+			t   time.Time
+			err error
+		)
+		switch zone := m[4]; {
+		case zone == "":
+			t, err = time.ParseInLocation("2006-01-02T15:04:05", s, time.Local)
+		case strings.Contains(zone, ":") || zone == "Z":
+			t, err = time.Parse("2006-01-02T15:04:05Z07:00", s+zone)
+		default:
+			t, err = time.Parse("2006-01-02T15:04:05Z0700", s+zone)
+		}
+		if err == nil {
+			return t, true
+		}
+	}
+
+	if m := clfTimestamp.FindStringSubmatch(line); m != nil {
+		if t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1]); err == nil {
+			return t, true
+		}
+	}
+
+	if m := syslogTimestamp.FindStringSubmatch(line); m != nil {
+		t, err := time.ParseInLocation("Jan _2 15:04:05", m[1], time.Local)
+		if err == nil {
+			t = t.AddDate(now.Year()-t.Year(), 0, 0)
+
+			// a December line read in January is from last year
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// parseTimeArg parses a time given on the command line: a timestamp like
+// those parseTimestamp understands, a date, or a duration before now
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if t, ok := parseTimestamp(s, now); ok {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid time %q, try 2006-01-02, 2006-01-02T15:04:05Z07:00 or a duration like 24h", s)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, time.January, 10, 12, 0, 0, 0, time.Local)
+	utc := func(s string) time.Time {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+
+	for line, want := range map[string]time.Time{
+		"2024-01-09T08:30:00Z GET /":                                    utc("2024-01-09T08:30:00Z"),
+		"2024-01-09T08:30:00.123+02:00 started":                         utc("2024-01-09T06:30:00.123Z"),
+		"2024-01-09 08:30:00,500+0100 INFO ready":                       utc("2024-01-09T07:30:00.5Z"),
+		"2024-01-09 08:30:00 no zone":                                   time.Date(2024, 1, 9, 8, 30, 0, 0, time.Local),
+		`1.2.3.4 - - [09/Jan/2024:08:30:00 -0500] "GET / HTTP/1.1" 200`: utc("2024-01-09T13:30:00Z"),
+		"Jan  9 08:30:00 host sshd[1]: accepted":                        time.Date(2024, 1, 9, 8, 30, 0, 0, time.Local),
+		"<13>Jan 10 11:00:00 host app: hello":                           time.Date(2024, 1, 10, 11, 0, 0, 0, time.Local),
+		"Dec 31 23:59:59 host app: last year":                           time.Date(2023, 12, 31, 23, 59, 59, 0, time.Local),
+	} {
+		got, ok := parseTimestamp(line, now)
+		assert.True(ok, line)
+		assert.True(want.Equal(got), "%s: expected %s, got %s", line, want, got)
+	}
+
+	for _, line := range []string{
+		"",
+		"no timestamp here",
+		"    at com.example.Main(Main.java:10)",
+		"too late " + string(make([]byte, timestampSearchLength)) + " 2024-01-09T08:30:00Z",
+	} {
+		_, ok := parseTimestamp(line, now)
+		assert.False(ok, line)
+	}
+}
+
+func TestParseTimeArg(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, time.January, 10, 12, 0, 0, 0, time.Local)
+
+	got, err := parseTimeArg("2024-01-09", now)
+	assert.NoError(err)
+	assert.Equal(time.Date(2024, 1, 9, 0, 0, 0, 0, time.Local), got)
+
+	got, err = parseTimeArg("2024-01-09T08:30:00Z", now)
+	assert.NoError(err)
+	assert.True(got.Equal(time.Date(2024, 1, 9, 8, 30, 0, 0, time.UTC)))
+
+	got, err = parseTimeArg("36h", now)
+	assert.NoError(err)
+	assert.Equal(now.Add(-36*time.Hour), got)
+
+	_, err = parseTimeArg("yesterday", now)
+	assert.Error(err)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Reactions to a file being truncated
+const (
+	// TruncateRestart reads the file again from the beginning, after
+	// sending what was left of the old contents from a copy of them, like
+	// logrotate's copytruncate makes
+	TruncateRestart = "restart"
+
+	// TruncateWarn only reports the truncation and leaves reading to
+	// carry on as the tailing library decides; it goes back to the
+	// beginning once it notices the file shrink
+	TruncateWarn = "warn"
+)
+
+func validOnTruncate(s string) error {
+	switch s {
+	case "", TruncateRestart, TruncateWarn:
+		return nil
+	}
+	return fmt.Errorf("Invalid on_truncate %q, try %s or %s", s, TruncateRestart, TruncateWarn)
+}
+
+// onTruncate returns how to react to lf's file being truncated
+func (s *Server) onTruncate(lf LogFile) string {
+	switch {
+	case lf.OnTruncate != "":
+		return lf.OnTruncate
+	case s.config.OnTruncate != "":
+		return s.config.OnTruncate
+	default:
+		return TruncateRestart
+	}
+}
+
+// reportTruncation logs and counts a file shrinking below what we've read
+func (s *Server) reportTruncation(file string, offset, size int64, reaction string) {
+	truncations.Add(file, 1)
+	log.Warningf("%s was truncated from offset %d to %d bytes (on_truncate: %s)", file, offset, size, reaction)
+}
@@ -23,7 +23,15 @@ func ResolvePath(path string) string {
 	return filepath.Join(os.Getenv("__DAEMON_CWD"), path)
 }
 
-func Daemonize(logFilePath, pidFilePath string) {
+// Detached reports whether this is the daemon Daemonize started, rather
+// than the process that ran it
+func Detached() bool {
+	return godaemon.Stage() != godaemon.StageParent
+}
+
+// Daemonize detaches from the terminal, copying the daemon's output to
+// logFile, and locks the pid file
+func Daemonize(logFile io.Writer, pidFilePath string) {
 
 	if os.Getenv("__DAEMON_CWD") == "" {
 		cwd, err := os.Getwd()
@@ -34,12 +42,6 @@ func Daemonize(logFilePath, pidFilePath string) {
 		os.Setenv("__DAEMON_CWD", cwd)
 	}
 
-	logFile, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not open local log file: %v\n", err)
-		os.Exit(1)
-	}
-
 	stdout, stderr, err := godaemon.MakeDaemon(&godaemon.DaemonAttr{CaptureOutput: true})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not Daemonize: %v\n", err)
@@ -53,10 +55,19 @@ func Daemonize(logFilePath, pidFilePath string) {
 		io.Copy(logFile, stderr)
 	}()
 
+	// stderr only reaches the log through the copies above, which exiting
+	// would cut short, so errors from here on are written to it directly.
+	// FindDaemon clears out a stale pid file, which the lock would take to
+	// be held if its pid had been reused.
+	if p, err := FindDaemon(pidFilePath); err == nil {
+		fmt.Fprintf(logFile, "Cannot lock \"%v\": already running as process %d\n", pidFilePath, p.Pid)
+		os.Exit(1)
+	}
+
 	lock, err := lockfile.New(pidFilePath)
 	err = lock.TryLock()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Cannot lock \"%v\": %v\n", lock, err)
+		fmt.Fprintf(logFile, "Cannot lock \"%v\": %v\n", lock, err)
 		os.Exit(1)
 	}
 
@@ -1,11 +1,33 @@
 package utils
 
+import (
+	"errors"
+	"io"
+	"time"
+)
+
 const CanDaemonize = false
 
 func ResolvePath(path string) string {
 	return path
 }
 
-func Daemonize(logFilePath, pidFilePath string) {
+func Daemonize(logFile io.Writer, pidFilePath string) {
 	panic("cannot daemonize on windows")
 }
+
+// ErrNotRunning is returned when the pid file doesn't name a running
+// remote_syslog
+var ErrNotRunning = errors.New("Not running")
+
+func Detached() bool {
+	return false
+}
+
+func ReloadDaemon(pidFilePath string) error {
+	return errors.New("Cannot reload on windows")
+}
+
+func StopDaemon(pidFilePath string, timeout time.Duration) error {
+	return errors.New("Cannot stop on windows")
+}
@@ -0,0 +1,48 @@
+// +build !windows
+
+package utils
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SdNotify tells systemd about the state of the service, like sd_notify(3),
+// by sending the states as the lines of a datagram to $NOTIFY_SOCKET. It
+// does nothing when the socket isn't set, as when not run by systemd with
+// Type=notify.
+func SdNotify(states ...string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// a leading @ is an abstract socket, which net handles for us
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+	return err
+}
+
+// SdWatchdogInterval returns how often systemd's watchdog should be sent
+// WATCHDOG=1: half of $WATCHDOG_USEC, as sd_watchdog_enabled(3) suggests.
+// It is 0 when the watchdog isn't enabled for this process.
+func SdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"time"
+)
+
+func SdNotify(states ...string) error {
+	// NOOP
+	return nil
+}
+
+func SdWatchdogInterval() time.Duration {
+	return 0
+}
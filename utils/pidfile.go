@@ -0,0 +1,159 @@
+// +build !windows
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+)
+
+// ErrNotRunning is returned when the pid file doesn't name a running
+// remote_syslog
+var ErrNotRunning = errors.New("Not running")
+
+// FindDaemon returns the running remote_syslog named by the pid file. A
+// pid file naming a process that has exited, or one that isn't
+// remote_syslog because its pid has been reused, is stale and removed.
+func FindDaemon(pidFilePath string) (*os.Process, error) {
+	path, err := filepath.Abs(pidFilePath)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockfile.New(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := lock.GetOwner()
+	switch {
+	case os.IsNotExist(err):
+		return nil, ErrNotRunning
+	case err == lockfile.ErrDeadOwner || err == lockfile.ErrInvalidPid:
+		return nil, removeStale(path)
+	case err != nil:
+		return nil, err
+	}
+
+	ours, err := isRemoteSyslog(p.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot tell what process %d is: %v", p.Pid, err)
+	}
+	if !ours {
+		return nil, removeStale(path)
+	}
+	return p, nil
+}
+
+func removeStale(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot remove stale pid file %s: %v", path, err)
+	}
+	return ErrNotRunning
+}
+
+// isRemoteSyslog reports whether the process is running the same program
+// as we are, going by the name of its executable
+func isRemoteSyslog(pid int) (bool, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return false, err
+	}
+	self = filepath.Base(self)
+
+	name, err := processName(pid)
+	if err != nil {
+		return false, err
+	}
+
+	// the kernel truncates names in /proc/PID/comm to 15 bytes
+	if len(name) == 15 {
+		return strings.HasPrefix(self, name), nil
+	}
+	return name == self, nil
+}
+
+// processName returns the name of the process's executable, from /proc
+// where there is one and ps otherwise
+func processName(pid int) (string, error) {
+	proc := "/proc/" + strconv.Itoa(pid)
+
+	// an upgrade replaces the executable from under a running process
+	if exe, err := os.Readlink(proc + "/exe"); err == nil {
+		return strings.TrimSuffix(filepath.Base(exe), " (deleted)"), nil
+	}
+
+	// only root can read another user's exe link, but anyone can read comm
+	if comm, err := ioutil.ReadFile(proc + "/comm"); err == nil {
+		return strings.TrimSpace(string(comm)), nil
+	}
+
+	out, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(strings.TrimSpace(string(out))), nil
+}
+
+// ReloadDaemon asks the running remote_syslog named by the pid file to
+// reload its configuration
+func ReloadDaemon(pidFilePath string) error {
+	p, err := FindDaemon(pidFilePath)
+	if err != nil {
+		return err
+	}
+	return p.Signal(syscall.SIGHUP)
+}
+
+// StopDaemon stops the running remote_syslog named by the pid file,
+// waiting up to timeout for it to exit before killing it, and removes the
+// pid file
+func StopDaemon(pidFilePath string, timeout time.Duration) error {
+	p, err := FindDaemon(pidFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+
+	if !waitExit(p, timeout) {
+		fmt.Fprintf(os.Stderr, "Process %d still running after %s, killing it\n", p.Pid, timeout)
+		if err := p.Kill(); err != nil {
+			return err
+		}
+		if !waitExit(p, 5*time.Second) {
+			return fmt.Errorf("Process %d still running after being killed", p.Pid)
+		}
+	}
+
+	// the daemon doesn't remove its pid file when it exits
+	if err := removeStale(pidFilePath); err != ErrNotRunning {
+		return err
+	}
+	return nil
+}
+
+// waitExit reports whether the process exits within timeout
+func waitExit(p *os.Process, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if p.Signal(syscall.Signal(0)) != nil {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"fmt"
+	"os/user"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// from linux/prctl.h and linux/capability.h
+const (
+	prSetKeepCaps           = 8
+	prCapAmbient            = 47
+	prCapAmbientRaise       = 2
+	capDacReadSearch        = 2
+	linuxCapabilityVersion3 = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// DropPrivileges switches the process to username and groupname, either of
+// which can be empty to leave it as it is, or numeric. The group defaults
+// to the user's primary group, and the user keeps their supplementary
+// groups, as login and initgroups would give them. With keepReadAccess, CAP_DAC_READ_SEARCH is
+// kept, and raised as an ambient capability, so any file can still be
+// read. That needs a binary built without cgo.
+func DropPrivileges(username, groupname string, keepReadAccess bool) error {
+	uid, gid, groups, err := lookupIDs(username, groupname)
+	if err != nil {
+		return err
+	}
+
+	if keepReadAccess {
+		// keep the permitted capabilities through setuid
+		if err := allThreadsPrctl(prSetKeepCaps, 1, 0); err != nil {
+			return fmt.Errorf("Failed to keep capabilities: %s", err)
+		}
+		defer allThreadsPrctl(prSetKeepCaps, 0, 0)
+	}
+
+	if gid >= 0 {
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("Failed to set groups: %s", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("Failed to set group %d: %s", gid, err)
+		}
+	}
+
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("Failed to set user %d: %s", uid, err)
+		}
+	}
+
+	if keepReadAccess {
+		if err := keepCapability(capDacReadSearch); err != nil {
+			return fmt.Errorf("Failed to keep CAP_DAC_READ_SEARCH: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// keepCapability makes capability the only one in the effective,
+// permitted, inheritable and ambient sets of every thread
+func keepCapability(capability uint) error {
+	header := capHeader{version: linuxCapabilityVersion3}
+	data := [2]capData{{
+		effective:   1 << capability,
+		permitted:   1 << capability,
+		inheritable: 1 << capability,
+	}}
+
+	_, _, errno := syscall.AllThreadsSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	runtime.KeepAlive(&header)
+	runtime.KeepAlive(&data)
+	if errno != 0 {
+		return allThreadsError(errno)
+	}
+
+	return allThreadsPrctl(prCapAmbient, prCapAmbientRaise, uintptr(capability))
+}
+
+func allThreadsPrctl(option, arg2, arg3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall6(syscall.SYS_PRCTL, option, arg2, arg3, 0, 0, 0)
+	if errno != 0 {
+		return allThreadsError(errno)
+	}
+	return nil
+}
+
+func allThreadsError(errno syscall.Errno) error {
+	if errno == syscall.ENOTSUP {
+		return fmt.Errorf("%s; capabilities can only be kept by a binary built with CGO_ENABLED=0", errno)
+	}
+	return errno
+}
+
+// lookupIDs returns the uid and gid to switch to, or -1 for either that
+// isn't changing, and the groups to set: the gid followed by the user's
+// supplementary groups
+func lookupIDs(username, groupname string) (uid, gid int, groups []int, err error) {
+	uid, gid = -1, -1
+	var u *user.User
+
+	if username != "" {
+		u, err = user.Lookup(username)
+		if _, ok := err.(user.UnknownUserError); ok {
+			u, err = user.LookupId(username)
+		}
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("Unknown user %s: %s", username, err)
+		}
+
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, nil, err
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+
+	if groupname != "" {
+		g, err := user.LookupGroup(groupname)
+		if _, ok := err.(user.UnknownGroupError); ok {
+			g, err = user.LookupGroupId(groupname)
+		}
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("Unknown group %s: %s", groupname, err)
+		}
+
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+
+	if gid < 0 {
+		return uid, gid, nil, nil
+	}
+	groups = []int{gid}
+	if u == nil {
+		return uid, gid, groups, nil
+	}
+
+	ids, err := u.GroupIds()
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("Cannot list the groups of user %s: %s", username, err)
+	}
+	for _, id := range ids {
+		group, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if group != gid {
+			groups = append(groups, group)
+		}
+	}
+	return uid, gid, groups, nil
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package utils
+
+import (
+	"fmt"
+)
+
+func DropPrivileges(username, groupname string, keepReadAccess bool) error {
+	return fmt.Errorf("Changing user and group is only supported on Linux")
+}
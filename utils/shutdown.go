@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// AddShutdownHandler calls f once when the process receives SIGINT or
+// SIGTERM. A second signal exits straight away, for when the shutdown is
+// stuck or taking too long.
+func AddShutdownHandler(f func()) {
+	sigChan := make(chan os.Signal, 2)
+	go func() {
+		<-sigChan
+
+		go func() {
+			sig := <-sigChan
+			fmt.Fprintf(os.Stderr, "Received %v while shutting down, exiting now\n", sig)
+			os.Exit(1)
+		}()
+
+		f()
+	}()
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+}
@@ -49,3 +49,27 @@ func AddSignalHandlers() {
 	}()
 	signal.Notify(sigChan, syscall.SIGUSR1)
 }
+
+// AddReopenHandler calls f each time the process receives SIGUSR2, which
+// logrotate can send once it has moved the log files away
+func AddReopenHandler(f func()) {
+	sigChan := make(chan os.Signal, 1)
+	go func() {
+		for range sigChan {
+			f()
+		}
+	}()
+	signal.Notify(sigChan, syscall.SIGUSR2)
+}
+
+// AddReloadHandler calls f each time the process receives SIGHUP. Calls
+// are made one at a time.
+func AddReloadHandler(f func()) {
+	sigChan := make(chan os.Signal, 1)
+	go func() {
+		for range sigChan {
+			f()
+		}
+	}()
+	signal.Notify(sigChan, syscall.SIGHUP)
+}
@@ -3,3 +3,11 @@ package utils
 func AddSignalHandlers() {
 	// NOOP
 }
+
+func AddReopenHandler(f func()) {
+	// NOOP
+}
+
+func AddReloadHandler(f func()) {
+	// NOOP
+}
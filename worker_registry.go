@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sort"
 	"sync"
 )
 
@@ -15,6 +16,9 @@ type WorkerRegistry interface {
 
 	// Remove clears a log file from the registry
 	Remove(worker string)
+
+	// List returns the log files currently being tailed, sorted
+	List() []string
 }
 
 // InMemoryRegistry is a simple WorkerRegistry implementation that uses a map protected by a sync.RWMutex.
@@ -47,3 +51,14 @@ func (imr *InMemoryRegistry) Remove(worker string) {
 	log.Tracef("Removing %s from worker registry", worker)
 	delete(imr.workers, worker)
 }
+
+func (imr *InMemoryRegistry) List() []string {
+	imr.mu.RLock()
+	defer imr.mu.RUnlock()
+	workers := make([]string, 0, len(imr.workers))
+	for worker := range imr.workers {
+		workers = append(workers, worker)
+	}
+	sort.Strings(workers)
+	return workers
+}